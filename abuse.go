@@ -0,0 +1,365 @@
+package go_verification
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+var ErrAbuseDetected = errors.New("request looks like abuse")
+
+// Attributes an AbuseGuard can check.
+const (
+	AttributeIP          = "ip"
+	AttributeASN         = "asn"
+	AttributeUserAgent   = "user_agent"
+	AttributeDestination = "destination"
+)
+
+// RequestAttributes describe who asks for a code. Empty fields are unknown.
+type RequestAttributes struct {
+	IP        string
+	ASN       string
+	UserAgent string
+	// Destination is where the code is sent, e.g. a phone number in E.164.
+	Destination string
+}
+
+// AbuseRequest is what an AbuseGuard checks before a code is generated.
+type AbuseRequest struct {
+	Username string
+	Scope    string
+	RequestAttributes
+}
+
+// AbuseReason explains one rule of an AbuseDecision.
+type AbuseReason struct {
+	// Rule is "allow_list", "deny_list" or "velocity".
+	Rule      string
+	Attribute string
+	// Value is the value or the prefix the rule matched.
+	Value  string
+	Count  int
+	Max    int
+	Window time.Duration
+}
+
+func (r AbuseReason) String() string {
+	if r.Rule == "velocity" {
+		return fmt.Sprintf("%s %s made %d requests in %s, max is %d", r.Attribute, r.Value, r.Count, r.Window, r.Max)
+	}
+	return fmt.Sprintf("%s %s is in the %s", r.Attribute, r.Value, strings.ReplaceAll(r.Rule, "_", " "))
+}
+
+type AbuseDecision struct {
+	Allowed bool
+	Reasons []AbuseReason
+	// RetryAfter is when a velocity limit allows the request again, zero for deny lists.
+	RetryAfter time.Duration
+	// Release gives back what Check counted for an allowed request. The handler calls it
+	// when the request fails later, e.g. on the resend backoff. Nil when nothing counted.
+	Release func() error `json:"-"`
+}
+
+// AbuseGuard decides whether GenerateCode or RegenerateCode may send a code, see
+// Config.AbuseGuard.
+type AbuseGuard interface {
+	Check(request AbuseRequest) (*AbuseDecision, error)
+}
+
+// AbuseError is returned by GenerateCode and RegenerateCode when the AbuseGuard denies
+// a request.
+// errors.Is matches it with ErrAbuseDetected.
+type AbuseError struct {
+	Decision *AbuseDecision
+}
+
+func (e *AbuseError) Error() string {
+	reasons := make([]string, len(e.Decision.Reasons))
+	for i, reason := range e.Decision.Reasons {
+		reasons[i] = reason.String()
+	}
+	return fmt.Sprintf("%s: %s", ErrAbuseDetected, strings.Join(reasons, ", "))
+}
+
+func (e *AbuseError) Is(target error) bool {
+	return target == ErrAbuseDetected
+}
+
+// RetryAfter makes the HTTP and gRPC APIs answer velocity limits with a rate limit.
+func (e *AbuseError) RetryAfter() time.Duration {
+	return e.Decision.RetryAfter
+}
+
+// WithRequestAttributes passes the attributes of the request to the AbuseGuard.
+func WithRequestAttributes(attributes RequestAttributes) GenerateOption {
+	return func(options *generateOptions) {
+		options.attributes = attributes
+	}
+}
+
+// checkAbuse asks the AbuseGuard of the handler, when there is one, about a request. It
+// returns a function giving back what the guard counted, for when the request fails later.
+func (v *VerificationCodeHandler) checkAbuse(username, scope string, attributes RequestAttributes) (func(), error) {
+	if v.config.AbuseGuard == nil {
+		return func() {}, nil
+	}
+	decision, err := v.config.AbuseGuard.Check(AbuseRequest{Username: username, Scope: scope, RequestAttributes: attributes})
+	if err != nil {
+		return nil, err
+	}
+	if !decision.Allowed {
+		return nil, &AbuseError{Decision: decision}
+	}
+	return func() {
+		if decision.Release != nil {
+			_ = decision.Release()
+		}
+	}, nil
+}
+
+// VelocityStore keeps the events of sliding windows. RedisCodeRepository keeps them in
+// sorted sets and MemoryCodeRepository in memory, EncryptedCodeRepository forwards it.
+type VelocityStore interface {
+	// ReserveEvent records an event for key now, kept for window, and counts the events
+	// key had in the last window, this one included, in one step so concurrent requests
+	// count each other. It returns the event for ReleaseEvent and when the oldest event
+	// happened.
+	ReserveEvent(key string, window time.Duration) (event string, count int, oldest time.Time, err error)
+	// ReleaseEvent removes an event ReserveEvent returned.
+	ReleaseEvent(key, event string) error
+}
+
+// newEventID returns a random event for ReserveEvent.
+func newEventID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := crand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// VelocityLimit allows Max requests with the same attribute value in every Window.
+type VelocityLimit struct {
+	Attribute string
+	// Prefix groups the values, it is the bits of IPs, e.g. 24, or the first characters
+	// of the other attributes, e.g. 6 for the country and area code of a destination.
+	// Zero uses whole values.
+	Prefix int
+	Window time.Duration
+	Max    int
+}
+
+type VelocityOptions struct {
+	Limits []VelocityLimit
+	// AllowList and DenyList are values by attribute. IPs can be CIDR prefixes, other
+	// values ending with '*' match by prefix. Allowed requests skip the limits.
+	AllowList map[string][]string
+	DenyList  map[string][]string
+}
+
+// VelocityGuard is an AbuseGuard with sliding window limits per attribute, so attackers
+// rotating phone numbers are caught by their IP, network or destination prefix.
+type VelocityGuard struct {
+	store   VelocityStore
+	options VelocityOptions
+}
+
+func NewVelocityGuard(store VelocityStore, options VelocityOptions) (*VelocityGuard, error) {
+	if !supports[VelocityStore](store) {
+		return nil, errors.New("repository does not support velocity limits")
+	}
+	for _, limit := range options.Limits {
+		if !knownAttribute(limit.Attribute) {
+			return nil, fmt.Errorf("unknown attribute %q", limit.Attribute)
+		}
+		if limit.Window <= 0 || limit.Max <= 0 {
+			return nil, fmt.Errorf("%s limit needs a window and a max", limit.Attribute)
+		}
+		if limit.Prefix < 0 || limit.Attribute == AttributeIP && limit.Prefix > 128 {
+			return nil, fmt.Errorf("invalid %s prefix %d", limit.Attribute, limit.Prefix)
+		}
+	}
+	for _, list := range []map[string][]string{options.AllowList, options.DenyList} {
+		for attribute, values := range list {
+			if !knownAttribute(attribute) {
+				return nil, fmt.Errorf("unknown attribute %q", attribute)
+			}
+			if attribute != AttributeIP {
+				continue
+			}
+			for _, value := range values {
+				if _, err := parseIPEntry(value); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return &VelocityGuard{store: store, options: options}, nil
+}
+
+// velocityEvent is an event Check reserved for a limit.
+type velocityEvent struct {
+	key   string
+	event string
+}
+
+// Check applies the deny list, the allow list and then the limits. It reserves an event
+// per limit, the events of a denied request are released right away and the ones of an
+// allowed request by the Release of the decision.
+func (g *VelocityGuard) Check(request AbuseRequest) (*AbuseDecision, error) {
+	values := request.values()
+	if reasons := g.listed(g.options.DenyList, "deny_list", values); len(reasons) > 0 {
+		return &AbuseDecision{Reasons: reasons}, nil
+	}
+	if reasons := g.listed(g.options.AllowList, "allow_list", values); len(reasons) > 0 {
+		return &AbuseDecision{Allowed: true, Reasons: reasons}, nil
+	}
+
+	decision := &AbuseDecision{Allowed: true}
+	var events []velocityEvent
+	for _, limit := range g.options.Limits {
+		value := limit.group(values[limit.Attribute])
+		if value == "" {
+			continue
+		}
+		key := limit.key(value)
+		event, count, oldest, err := g.store.ReserveEvent(key, limit.Window)
+		if err != nil {
+			_ = g.release(events)
+			return nil, err
+		}
+		events = append(events, velocityEvent{key: key, event: event})
+		if count <= limit.Max {
+			continue
+		}
+		decision.Allowed = false
+		decision.Reasons = append(decision.Reasons, AbuseReason{
+			Rule: "velocity", Attribute: limit.Attribute, Value: value, Count: count - 1, Max: limit.Max, Window: limit.Window,
+		})
+		if retryAfter := time.Until(oldest.Add(limit.Window)); retryAfter > decision.RetryAfter {
+			decision.RetryAfter = retryAfter
+		}
+	}
+	if !decision.Allowed {
+		if err := g.release(events); err != nil {
+			return nil, err
+		}
+		if decision.RetryAfter <= 0 {
+			decision.RetryAfter = time.Second
+		}
+		return decision, nil
+	}
+	if len(events) > 0 {
+		decision.Release = func() error {
+			return g.release(events)
+		}
+	}
+	return decision, nil
+}
+
+// release removes the events Check reserved for a request.
+func (g *VelocityGuard) release(events []velocityEvent) error {
+	var first error
+	for _, event := range events {
+		if err := g.store.ReleaseEvent(event.key, event.event); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (g *VelocityGuard) listed(list map[string][]string, rule string, values map[string]string) []AbuseReason {
+	var reasons []AbuseReason
+	for attribute, entries := range list {
+		value := values[attribute]
+		if value == "" {
+			continue
+		}
+		for _, entry := range entries {
+			if matchesEntry(attribute, entry, value) {
+				reasons = append(reasons, AbuseReason{Rule: rule, Attribute: attribute, Value: entry})
+				break
+			}
+		}
+	}
+	return reasons
+}
+
+func (r AbuseRequest) values() map[string]string {
+	return map[string]string{
+		AttributeIP:          r.IP,
+		AttributeASN:         r.ASN,
+		AttributeUserAgent:   r.UserAgent,
+		AttributeDestination: r.Destination,
+	}
+}
+
+// key returns the key of the events of the limit for a grouped value.
+func (l VelocityLimit) key(value string) string {
+	return fmt.Sprintf("%s:%d:%s", l.Attribute, l.Window.Milliseconds(), value)
+}
+
+// group returns the prefix of value the limit counts requests by.
+func (l VelocityLimit) group(value string) string {
+	if value == "" || l.Prefix == 0 {
+		return value
+	}
+	if l.Attribute == AttributeIP {
+		ip, err := netip.ParseAddr(value)
+		if err != nil {
+			return value
+		}
+		ip = ip.Unmap()
+		bits := l.Prefix
+		if bits > ip.BitLen() {
+			bits = ip.BitLen()
+		}
+		prefix, _ := ip.Prefix(bits)
+		return prefix.String()
+	}
+	if len(value) > l.Prefix {
+		return value[:l.Prefix]
+	}
+	return value
+}
+
+func matchesEntry(attribute, entry, value string) bool {
+	if attribute == AttributeIP {
+		prefix, err := parseIPEntry(entry)
+		ip, ipErr := netip.ParseAddr(value)
+		return err == nil && ipErr == nil && prefix.Contains(ip.Unmap())
+	}
+	if prefix, ok := strings.CutSuffix(entry, "*"); ok {
+		return strings.HasPrefix(value, prefix)
+	}
+	return entry == value
+}
+
+func parseIPEntry(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid IP prefix %q", entry)
+		}
+		return prefix.Masked(), nil
+	}
+	ip, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP %q", entry)
+	}
+	ip = ip.Unmap()
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+func knownAttribute(attribute string) bool {
+	switch attribute {
+	case AttributeIP, AttributeASN, AttributeUserAgent, AttributeDestination:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package go_verification
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVelocityGuard(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, repository CodeRepositoryInterface) {
+		store := repository.(VelocityStore)
+		guard, err := NewVelocityGuard(store, VelocityOptions{
+			Limits: []VelocityLimit{
+				{Attribute: AttributeIP, Prefix: 24, Window: time.Minute, Max: 2},
+				{Attribute: AttributeDestination, Prefix: 6, Window: time.Hour, Max: 3},
+			},
+			AllowList: map[string][]string{AttributeIP: {"198.51.100.0/24"}},
+			DenyList:  map[string][]string{AttributeASN: {"AS64496"}, AttributeUserAgent: {"curl/*"}},
+		})
+		if err != nil {
+			t.Fatalf("NewVelocityGuard error: %v", err)
+		}
+		check := func(attributes RequestAttributes) *AbuseDecision {
+			decision, err := guard.Check(AbuseRequest{Username: attributes.Destination, Scope: "login", RequestAttributes: attributes})
+			if err != nil {
+				t.Fatalf("Check error: %v", err)
+			}
+			return decision
+		}
+
+		for i := 0; i < 2; i++ {
+			if decision := check(RequestAttributes{IP: fmt.Sprintf("203.0.113.%d", i), Destination: fmt.Sprintf("+98912000000%d", i)}); !decision.Allowed {
+				t.Errorf("Expected request %d to be allowed, got %+v", i, decision)
+			}
+		}
+		decision := check(RequestAttributes{IP: "203.0.113.77", Destination: "+989120000009"})
+		if decision.Allowed || len(decision.Reasons) != 1 || decision.Reasons[0].Value != "203.0.113.0/24" || decision.RetryAfter <= 0 {
+			t.Errorf("Expected the network to be limited, got %+v", decision)
+		}
+
+		// denied requests are not counted, so the destination prefix has one request left
+		if decision := check(RequestAttributes{IP: "192.0.2.1", Destination: "+989120000005"}); !decision.Allowed {
+			t.Errorf("Expected the 3rd request of the prefix to be allowed, got %+v", decision)
+		}
+		decision = check(RequestAttributes{IP: "192.0.2.2", Destination: "+989120000006"})
+		if decision.Allowed || decision.Reasons[0].Attribute != AttributeDestination || decision.Reasons[0].Value != "+98912" {
+			t.Errorf("Expected the destination prefix to be limited, got %+v", decision)
+		}
+
+		decision = check(RequestAttributes{IP: "198.51.100.7", Destination: "+989120000007"})
+		if !decision.Allowed || decision.Reasons[0].Rule != "allow_list" {
+			t.Errorf("Expected the allow list to skip the limits, got %+v", decision)
+		}
+		decision = check(RequestAttributes{IP: "198.51.100.7", ASN: "AS64496"})
+		if decision.Allowed || decision.Reasons[0].Rule != "deny_list" || decision.RetryAfter != 0 {
+			t.Errorf("Expected the deny list to win, got %+v", decision)
+		}
+		if decision := check(RequestAttributes{UserAgent: "curl/8.0"}); decision.Allowed {
+			t.Errorf("Expected user agents to match by prefix, got %+v", decision)
+		}
+	})
+}
+
+func TestVelocityGuard_Concurrent(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, repository CodeRepositoryInterface) {
+		guard, err := NewVelocityGuard(repository.(VelocityStore), VelocityOptions{
+			Limits: []VelocityLimit{{Attribute: AttributeIP, Window: time.Minute, Max: 3}},
+		})
+		if err != nil {
+			t.Fatalf("NewVelocityGuard error: %v", err)
+		}
+		var wg sync.WaitGroup
+		allowed := make(chan *AbuseDecision, 50)
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				decision, err := guard.Check(AbuseRequest{Username: fmt.Sprintf("user%d", i), Scope: "login", RequestAttributes: RequestAttributes{IP: "203.0.113.7"}})
+				if err != nil {
+					t.Errorf("Check error: %v", err)
+				} else if decision.Allowed {
+					allowed <- decision
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(allowed)
+		if len(allowed) != 3 {
+			t.Fatalf("Expected 3 requests to be allowed, but got %d", len(allowed))
+		}
+
+		// a released request can be made again
+		if err := (<-allowed).Release(); err != nil {
+			t.Fatalf("Release error: %v", err)
+		}
+		if decision, _ := guard.Check(AbuseRequest{Username: "user", Scope: "login", RequestAttributes: RequestAttributes{IP: "203.0.113.7"}}); decision == nil || !decision.Allowed {
+			t.Errorf("Expected the released request to be given back, got %+v", decision)
+		}
+	})
+}
+
+func TestNewVelocityGuard(t *testing.T) {
+	tests := []struct {
+		name    string
+		options VelocityOptions
+	}{
+		{"unknown attribute", VelocityOptions{Limits: []VelocityLimit{{Attribute: "country", Window: time.Minute, Max: 1}}}},
+		{"no window", VelocityOptions{Limits: []VelocityLimit{{Attribute: AttributeIP, Max: 1}}}},
+		{"invalid prefix", VelocityOptions{Limits: []VelocityLimit{{Attribute: AttributeIP, Prefix: 129, Window: time.Minute, Max: 1}}}},
+		{"invalid IP", VelocityOptions{DenyList: map[string][]string{AttributeIP: {"10.0.0.0/33"}}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewVelocityGuard(NewMemoryCodeRepository(), test.options); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestVerificationCodeHandler_AbuseGuard(t *testing.T) {
+	repository := NewMemoryCodeRepository()
+	guard, _ := NewVelocityGuard(repository, VelocityOptions{
+		Limits: []VelocityLimit{{Attribute: AttributeIP, Window: time.Minute, Max: 2}},
+	})
+	handler, _ := NewVerificationCodeHandler(&MockCodeGenerator{defCode: "123456"}, repository, &Config{AbuseGuard: guard})
+
+	for i := 0; i < 3; i++ {
+		_, err := handler.GenerateCode(fmt.Sprintf("+98912000000%d", i), "login", WithRequestAttributes(RequestAttributes{IP: "203.0.113.7"}))
+		if i < 2 && err != nil {
+			t.Errorf("Expected code %d to be generated, got %v", i, err)
+		}
+		if i == 2 {
+			var abuse *AbuseError
+			if !errors.As(err, &abuse) || !errors.Is(err, ErrAbuseDetected) || abuse.RetryAfter() <= 0 {
+				t.Fatalf("Expected an AbuseError, but got %v", err)
+			}
+			if !strings.Contains(err.Error(), "ip 203.0.113.7 made 2 requests") {
+				t.Errorf("Expected the reason in the error, got %q", err)
+			}
+		}
+	}
+	if _, err := repository.GetCode("+989120000002", "login"); !errors.Is(err, ErrCodeNotFound) {
+		t.Errorf("Expected no code for the denied request, got %v", err)
+	}
+}
+
+func TestVerificationCodeHandler_AbuseGuardResend(t *testing.T) {
+	repository := NewMemoryCodeRepository()
+	guard, _ := NewVelocityGuard(repository, VelocityOptions{
+		Limits: []VelocityLimit{{Attribute: AttributeIP, Window: time.Minute, Max: 2}},
+	})
+	handler, _ := NewVerificationCodeHandler(&MockCodeGenerator{length: 8}, repository, &Config{
+		AbuseGuard:    guard,
+		ResendBackoff: ResendBackoff{Intervals: []time.Duration{time.Minute}},
+	})
+	attributes := WithRequestAttributes(RequestAttributes{IP: "203.0.113.7"})
+
+	if _, err := handler.GenerateCode("+989120000001", "login", attributes); err != nil {
+		t.Fatalf("GenerateCode error: %v", err)
+	}
+	if _, err := handler.RegenerateCode("+989120000001", "login", false, attributes); !errors.Is(err, ErrResendTooSoon) {
+		t.Fatalf("Expected ErrResendTooSoon, but got %v", err)
+	}
+	// the rejected resend is not counted, so the IP has one request left
+	if _, err := handler.GenerateCode("+989120000002", "login", attributes); err != nil {
+		t.Fatalf("Expected the 2nd request to be allowed, but got %v", err)
+	}
+	if _, err := handler.RegenerateCode("+989120000002", "login", false, attributes); !errors.Is(err, ErrAbuseDetected) {
+		t.Errorf("Expected ErrAbuseDetected, but got %v", err)
+	}
+}
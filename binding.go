@@ -0,0 +1,151 @@
+package go_verification
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+var (
+	ErrBindingMismatch = errors.New("code was requested from another session, device or network")
+	// ErrInvalidBinding is returned by GenerateCode when the BindingPolicy of the scope
+	// requires an attribute the Binding doesn't have, or its IP is invalid.
+	ErrInvalidBinding = errors.New("invalid binding")
+)
+
+// Metadata keys the binding of a code is stored under. Sessions and devices are stored
+// as SHA-256 hashes.
+const (
+	BindingSessionKey = "binding.session"
+	BindingDeviceKey  = "binding.device"
+	BindingIPKey      = "binding.ip"
+)
+
+// Binding is where a code is requested or presented from. Empty fields are unknown.
+type Binding struct {
+	Session string
+	Device  string
+	IP      string
+}
+
+// BindingPolicy chooses which attributes of the Binding of GenerateCode a code must be
+// presented with. GenerateCode fails with ErrInvalidBinding when a required attribute is
+// missing.
+type BindingPolicy struct {
+	Session bool
+	Device  bool
+	IP      bool
+	// IPv4Prefix and IPv6Prefix are how many leading bits of the IP must match, e.g. 24
+	// or 64. They default to the whole address.
+	IPv4Prefix int
+	IPv6Prefix int
+}
+
+// WithBinding records binding with the code. It is stored with the metadata of the code,
+// under the Binding*Key keys.
+func WithBinding(binding Binding) GenerateOption {
+	return func(options *generateOptions) {
+		options.binding = &binding
+	}
+}
+
+// CheckOption changes how CheckCode and VerifyCode check a code.
+type CheckOption func(*checkOptions)
+
+type checkOptions struct {
+	binding Binding
+}
+
+// FromBinding is where a code is presented from, it is compared with the Binding of
+// GenerateCode when the scope has a BindingPolicy.
+func FromBinding(binding Binding) CheckOption {
+	return func(options *checkOptions) {
+		options.binding = binding
+	}
+}
+
+func (p BindingPolicy) enabled() bool {
+	return p.Session || p.Device || p.IP
+}
+
+func (p BindingPolicy) check() error {
+	if p.IPv4Prefix < 0 || p.IPv4Prefix > 32 {
+		return fmt.Errorf("invalid IPv4 prefix %d", p.IPv4Prefix)
+	}
+	if p.IPv6Prefix < 0 || p.IPv6Prefix > 128 {
+		return fmt.Errorf("invalid IPv6 prefix %d", p.IPv6Prefix)
+	}
+	return nil
+}
+
+// metadata returns the metadata keys of binding, with the session and device hashed and
+// the IP in its canonical form.
+func (p BindingPolicy) metadata(binding Binding) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if binding.Session != "" {
+		metadata[BindingSessionKey] = hashBinding(BindingSessionKey, binding.Session)
+	} else if p.Session {
+		return nil, fmt.Errorf("%w: session is required", ErrInvalidBinding)
+	}
+	if binding.Device != "" {
+		metadata[BindingDeviceKey] = hashBinding(BindingDeviceKey, binding.Device)
+	} else if p.Device {
+		return nil, fmt.Errorf("%w: device is required", ErrInvalidBinding)
+	}
+	if binding.IP != "" {
+		ip, err := netip.ParseAddr(binding.IP)
+		if err != nil {
+			return nil, fmt.Errorf("%w: IP %q", ErrInvalidBinding, binding.IP)
+		}
+		metadata[BindingIPKey] = ip.Unmap().String()
+	} else if p.IP {
+		return nil, fmt.Errorf("%w: IP is required", ErrInvalidBinding)
+	}
+	return metadata, nil
+}
+
+// matches compares the binding a code is presented from with the one it was generated for.
+func (p BindingPolicy) matches(metadata map[string]string, binding Binding) bool {
+	if p.Session && (binding.Session == "" || hashBinding(BindingSessionKey, binding.Session) != metadata[BindingSessionKey]) {
+		return false
+	}
+	if p.Device && (binding.Device == "" || hashBinding(BindingDeviceKey, binding.Device) != metadata[BindingDeviceKey]) {
+		return false
+	}
+	if p.IP && !p.sameNetwork(metadata[BindingIPKey], binding.IP) {
+		return false
+	}
+	return true
+}
+
+// hashBinding keeps session IDs and device fingerprints out of the stored metadata.
+func hashBinding(key, value string) string {
+	sum := sha256.Sum256([]byte(key + "\x00" + value))
+	return hex.EncodeToString(sum[:])
+}
+
+func (p BindingPolicy) sameNetwork(generated, presented string) bool {
+	a, err := netip.ParseAddr(generated)
+	if err != nil {
+		return false
+	}
+	b, err := netip.ParseAddr(presented)
+	if err != nil {
+		return false
+	}
+	a, b = a.Unmap(), b.Unmap()
+	if a.Is4() != b.Is4() {
+		return false
+	}
+	bits := p.IPv6Prefix
+	if a.Is4() {
+		bits = p.IPv4Prefix
+	}
+	if bits == 0 {
+		bits = a.BitLen()
+	}
+	prefix, err := a.Prefix(bits)
+	return err == nil && prefix.Contains(b)
+}
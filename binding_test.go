@@ -0,0 +1,91 @@
+package go_verification
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerificationCodeHandler_Binding(t *testing.T) {
+	config := &Config{Scopes: map[string]ScopePolicy{
+		"login":    {Binding: BindingPolicy{Session: true, IP: true, IPv4Prefix: 24, IPv6Prefix: 64}},
+		"checkout": {Binding: BindingPolicy{Device: true}},
+	}}
+	handler, err := NewVerificationCodeHandler(&MockCodeGenerator{defCode: "123456"}, NewMemoryCodeRepository(), config)
+	if err != nil {
+		t.Fatalf("NewVerificationCodeHandler error: %v", err)
+	}
+	if _, err := handler.GenerateCode("testuser", "login", WithBinding(Binding{Session: "s1", IP: "203.0.113.7"})); err != nil {
+		t.Fatalf("GenerateCode error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		binding Binding
+		err     error
+	}{
+		{"same network", Binding{Session: "s1", IP: "203.0.113.200"}, nil},
+		{"mapped address", Binding{Session: "s1", IP: "::ffff:203.0.113.9"}, nil},
+		{"other network", Binding{Session: "s1", IP: "203.0.114.7"}, ErrBindingMismatch},
+		{"other family", Binding{Session: "s1", IP: "2001:db8::1"}, ErrBindingMismatch},
+		{"other session", Binding{Session: "s2", IP: "203.0.113.7"}, ErrBindingMismatch},
+		{"no binding", Binding{}, ErrBindingMismatch},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := handler.CheckCode("testuser", "123456", "login", FromBinding(test.binding))
+			if !errors.Is(err, test.err) {
+				t.Errorf("Expected %v, but got %v", test.err, err)
+			}
+		})
+	}
+
+	t.Run("invalid code first", func(t *testing.T) {
+		if _, err := handler.CheckCode("testuser", "000000", "login"); !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("Expected ErrInvalidCode, but got %v", err)
+		}
+	})
+
+	t.Run("required attributes", func(t *testing.T) {
+		if _, err := handler.GenerateCode("testuser", "checkout"); !errors.Is(err, ErrInvalidBinding) {
+			t.Errorf("Expected ErrInvalidBinding without a binding, but got %v", err)
+		}
+		if _, err := handler.GenerateCode("testuser", "checkout", WithBinding(Binding{Session: "s1"})); !errors.Is(err, ErrInvalidBinding) {
+			t.Errorf("Expected ErrInvalidBinding without a device, but got %v", err)
+		}
+	})
+
+	t.Run("regenerate keeps the binding", func(t *testing.T) {
+		_, _ = handler.GenerateCode("testuser", "checkout", WithBinding(Binding{Device: "d1"}))
+		if _, err := handler.RegenerateCode("testuser", "checkout", true); err != nil {
+			t.Fatalf("RegenerateCode error: %v", err)
+		}
+		verification, err := handler.VerifyCode("testuser", "123456", "checkout", FromBinding(Binding{Device: "d1"}))
+		if err != nil || verification.Code.Metadata[BindingDeviceKey] != hashBinding(BindingDeviceKey, "d1") {
+			t.Errorf("Expected the regenerated code to keep its binding, got %+v, %v", verification, err)
+		}
+	})
+
+	t.Run("sessions and devices are hashed", func(t *testing.T) {
+		code, err := handler.GenerateCode("otheruser", "login", WithBinding(Binding{Session: "s1", IP: "203.0.113.7"}))
+		if err != nil {
+			t.Fatalf("GenerateCode error: %v", err)
+		}
+		if code.Metadata[BindingSessionKey] == "s1" || code.Metadata[BindingIPKey] != "203.0.113.7" {
+			t.Errorf("Expected a hashed session and a plain IP, but got %v", code.Metadata)
+		}
+	})
+
+	t.Run("scopes without policy", func(t *testing.T) {
+		_, _ = handler.GenerateCode("testuser", "other")
+		if _, err := handler.CheckCode("testuser", "123456", "other", FromBinding(Binding{Session: "x"})); err != nil {
+			t.Errorf("Expected no binding check, got %v", err)
+		}
+	})
+}
+
+func TestNewVerificationCodeHandler_BindingPolicy(t *testing.T) {
+	config := &Config{Scopes: map[string]ScopePolicy{"login": {Binding: BindingPolicy{IP: true, IPv4Prefix: 33}}}}
+	if _, err := NewVerificationCodeHandler(&MockCodeGenerator{defCode: "123456"}, NewMemoryCodeRepository(), config); err == nil {
+		t.Error("Expected an error for an invalid prefix")
+	}
+}
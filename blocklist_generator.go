@@ -0,0 +1,241 @@
+package go_verification
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+//go:embed wordlists/blocklist.txt
+var defaultBlockedWords string
+
+// ErrBlocklistExhausted is returned when every retry generated a blocked code.
+var ErrBlocklistExhausted = errors.New("could not generate a code outside the blocklist")
+
+// leetReplacer reads the digits of a code as the letters they look like.
+var leetReplacer = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "8", "b")
+
+type Blocklist struct {
+	// Substrings are matched case-insensitively against the letters and digits of a code,
+	// also after reading digits as letters (sh1t). Codes of a PassphraseGenerator only
+	// match them as whole words, so canal isn't blocked for anal.
+	Substrings []string
+	// Patterns are regular expressions matched against the code as generated.
+	Patterns []string
+	// RepeatedRun rejects codes with this many identical characters in a row, e.g. 0000. Zero disables it.
+	RepeatedRun int
+	// SequentialRun rejects codes with this many ascending or descending digits in a row, e.g. 1234. Zero disables it.
+	SequentialRun int
+}
+
+// DefaultBlocklist returns the embedded list of offensive words and rejects runs of 4
+// repeated or sequential digits.
+func DefaultBlocklist() Blocklist {
+	return Blocklist{
+		Substrings:    strings.Fields(defaultBlockedWords),
+		RepeatedRun:   4,
+		SequentialRun: 4,
+	}
+}
+
+type BlocklistGenerator struct {
+	generator     CodeGenerator
+	substrings    []string
+	patterns      []*regexp.Regexp
+	repeatedRun   int
+	sequentialRun int
+	maxRetries    int
+	// separator splits the words of passphrases, it is empty for other generators.
+	separator string
+}
+
+// NewBlocklistGenerator wraps generator and generates again, at most maxRetries times,
+// whenever a code matches blocklist.
+func NewBlocklistGenerator(generator CodeGenerator, blocklist Blocklist, maxRetries int) (*BlocklistGenerator, error) {
+	if generator == nil {
+		return nil, errors.New("generator is nil")
+	}
+	if maxRetries < 0 {
+		return nil, errors.New("max retries can not be negative")
+	}
+	if blocklist.RepeatedRun == 1 || blocklist.RepeatedRun < 0 {
+		return nil, fmt.Errorf("invalid repeated run %d", blocklist.RepeatedRun)
+	}
+	if blocklist.SequentialRun == 1 || blocklist.SequentialRun < 0 {
+		return nil, fmt.Errorf("invalid sequential run %d", blocklist.SequentialRun)
+	}
+
+	b := &BlocklistGenerator{
+		generator:     generator,
+		repeatedRun:   blocklist.RepeatedRun,
+		sequentialRun: blocklist.SequentialRun,
+		maxRetries:    maxRetries,
+	}
+	if passphrase, ok := generator.(*PassphraseGenerator); ok {
+		b.separator = passphrase.separator
+	}
+	for _, substring := range blocklist.Substrings {
+		substring = strings.ToLower(strings.TrimSpace(substring))
+		if substring != "" {
+			b.substrings = append(b.substrings, substring)
+		}
+	}
+	for _, pattern := range blocklist.Patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blocklist pattern %q: %w", pattern, err)
+		}
+		b.patterns = append(b.patterns, compiled)
+	}
+	return b, nil
+}
+
+func (b *BlocklistGenerator) Generate() string {
+	code, _ := b.GenerateE()
+	return code
+}
+
+func (b *BlocklistGenerator) GenerateE() (string, error) {
+	for i := 0; i <= b.maxRetries; i++ {
+		code, err := b.next()
+		if err != nil {
+			return "", err
+		}
+		if !b.Blocked(code) {
+			return code, nil
+		}
+	}
+	return "", ErrBlocklistExhausted
+}
+
+func (b *BlocklistGenerator) next() (string, error) {
+	if generator, ok := b.generator.(FallibleGenerator); ok {
+		return generator.GenerateE()
+	}
+	return b.generator.Generate(), nil
+}
+
+// Blocked reports whether code matches the blocklist.
+func (b *BlocklistGenerator) Blocked(code string) bool {
+	for _, pattern := range b.patterns {
+		if pattern.MatchString(code) {
+			return true
+		}
+	}
+
+	plain, hasLetters := b.plain(code)
+	if b.separator != "" {
+		for _, word := range strings.Split(code, b.separator) {
+			if word, _ := b.plain(word); b.containsSubstring(word, true) {
+				return true
+			}
+		}
+	} else if b.containsSubstring(plain, hasLetters) {
+		return true
+	}
+
+	return b.hasRepeatedRun(plain) || b.hasSequentialRun(plain)
+}
+
+// containsSubstring matches the substrings in code, or only whole codes for passphrase
+// words, also after reading its digits as letters when it has letters.
+func (b *BlocklistGenerator) containsSubstring(code string, hasLetters bool) bool {
+	match := strings.Contains
+	if b.separator != "" {
+		match = func(word, substring string) bool { return word == substring }
+	}
+	candidates := []string{code}
+	if hasLetters {
+		candidates = append(candidates, leetReplacer.Replace(code))
+	}
+	for _, candidate := range candidates {
+		for _, substring := range b.substrings {
+			if match(candidate, substring) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (b *BlocklistGenerator) plain(code string) (string, bool) {
+	var result strings.Builder
+	hasLetters := false
+	for _, char := range code {
+		if unicode.IsLetter(char) {
+			hasLetters = true
+			result.WriteRune(unicode.ToLower(char))
+		} else if unicode.IsDigit(char) {
+			result.WriteRune(char)
+		}
+	}
+	return result.String(), hasLetters
+}
+
+func (b *BlocklistGenerator) hasRepeatedRun(code string) bool {
+	if b.repeatedRun == 0 {
+		return false
+	}
+	run := 1
+	for i := 1; i < len(code); i++ {
+		if code[i] == code[i-1] {
+			run++
+		} else {
+			run = 1
+		}
+		if run >= b.repeatedRun {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *BlocklistGenerator) hasSequentialRun(code string) bool {
+	if b.sequentialRun == 0 {
+		return false
+	}
+	ascending, descending := 1, 1
+	for i := 1; i < len(code); i++ {
+		if !isDigit(code[i]) || !isDigit(code[i-1]) {
+			ascending, descending = 1, 1
+			continue
+		}
+		if code[i] == code[i-1]+1 {
+			ascending++
+		} else {
+			ascending = 1
+		}
+		if code[i] == code[i-1]-1 {
+			descending++
+		} else {
+			descending = 1
+		}
+		if ascending >= b.sequentialRun || descending >= b.sequentialRun {
+			return true
+		}
+	}
+	return false
+}
+
+// Entropy returns the entropy of the wrapped generator. Blocked codes make the keyspace a
+// little smaller than that, more so with short substrings.
+func (b *BlocklistGenerator) Entropy() float64 {
+	if reporter, ok := b.generator.(EntropyReporter); ok {
+		return reporter.Entropy()
+	}
+	return 0
+}
+
+func (b *BlocklistGenerator) Normalize(code string) string {
+	if normalizer, ok := b.generator.(CodeNormalizer); ok {
+		return normalizer.Normalize(code)
+	}
+	return code
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
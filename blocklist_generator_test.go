@@ -0,0 +1,124 @@
+package go_verification
+
+import (
+	"errors"
+	"testing"
+)
+
+type sequenceGenerator struct {
+	codes []string
+	next  int
+}
+
+func (s *sequenceGenerator) Generate() string {
+	code := s.codes[s.next%len(s.codes)]
+	s.next++
+	return code
+}
+
+func TestBlocklistGeneratorBlocked(t *testing.T) {
+	gen, err := NewBlocklistGenerator(mustGenerator(NewNumberGenerator(6, false)), Blocklist{
+		Substrings:    []string{"shit"},
+		Patterns:      []string{`^99`},
+		RepeatedRun:   4,
+		SequentialRun: 4,
+	}, 10)
+	if err != nil {
+		t.Fatalf("NewBlocklistGenerator error: %v", err)
+	}
+
+	tests := []struct {
+		code    string
+		blocked bool
+	}{
+		{"000000", true},
+		{"120000", true},
+		{"123456", true},
+		{"987650", true},
+		{"990412", true},
+		{"SHIT42", true},
+		{"5h1t42", true},
+		{"sh-it4", true},
+		{"112233", false},
+		{"123579", false},
+		{"53174a", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if result := gen.Blocked(tt.code); result != tt.blocked {
+				t.Errorf("Expected blocked %v for %s, but got %v", tt.blocked, tt.code, result)
+			}
+		})
+	}
+}
+
+func TestBlocklistGeneratorBlockedPassphrase(t *testing.T) {
+	gen, err := NewBlocklistGenerator(mustGenerator(NewPassphraseGenerator(nil, 3, "-", LowerCase)), DefaultBlocklist(), 10)
+	if err != nil {
+		t.Fatalf("NewBlocklistGenerator error: %v", err)
+	}
+
+	tests := []struct {
+		code    string
+		blocked bool
+	}{
+		{"document-appetite-canal", false},
+		{"Document-Appetite-Canal", false},
+		{"amber-anal-river", true},
+		{"amber-4nal-river", true},
+	}
+	for _, tt := range tests {
+		if got := gen.Blocked(tt.code); got != tt.blocked {
+			t.Errorf("Expected Blocked(%q) to be %v, but got %v", tt.code, tt.blocked, got)
+		}
+	}
+}
+
+func TestBlocklistGeneratorGenerate(t *testing.T) {
+	t.Run("Test blocked codes are regenerated", func(t *testing.T) {
+		gen, _ := NewBlocklistGenerator(&sequenceGenerator{codes: []string{"000000", "123456", "502817"}}, DefaultBlocklist(), 5)
+
+		code, err := gen.GenerateE()
+		if err != nil {
+			t.Fatalf("GenerateE error: %v", err)
+		}
+		if code != "502817" {
+			t.Errorf("Expected 502817, but got %s", code)
+		}
+	})
+
+	t.Run("Test retries are bounded", func(t *testing.T) {
+		inner := &sequenceGenerator{codes: []string{"111111"}}
+		gen, _ := NewBlocklistGenerator(inner, DefaultBlocklist(), 3)
+
+		_, err := gen.GenerateE()
+		if !errors.Is(err, ErrBlocklistExhausted) {
+			t.Errorf("Expected ErrBlocklistExhausted, but got %v", err)
+		}
+		if inner.next != 4 {
+			t.Errorf("Expected 4 attempts, but got %d", inner.next)
+		}
+	})
+
+	t.Run("Test default blocklist", func(t *testing.T) {
+		gen, _ := NewBlocklistGenerator(mustGenerator(NewWordGenerator(8)), DefaultBlocklist(), 10)
+		for i := 0; i < 100; i++ {
+			if code := gen.Generate(); gen.Blocked(code) {
+				t.Errorf("Expected code outside the blocklist, but got %s", code)
+			}
+		}
+	})
+}
+
+func TestNewBlocklistGenerator(t *testing.T) {
+	if _, err := NewBlocklistGenerator(mustGenerator(NewNumberGenerator(6, false)), Blocklist{Patterns: []string{"("}}, 3); err == nil {
+		t.Error("Expected error for invalid pattern")
+	}
+	if _, err := NewBlocklistGenerator(mustGenerator(NewNumberGenerator(6, false)), DefaultBlocklist(), -1); err == nil {
+		t.Error("Expected error for negative retries")
+	}
+	if _, err := NewBlocklistGenerator(nil, DefaultBlocklist(), 3); err == nil {
+		t.Error("Expected error for nil generator")
+	}
+}
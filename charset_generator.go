@@ -0,0 +1,156 @@
+package go_verification
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+)
+
+const (
+	// CrockfordCharset is Douglas Crockford's Base32 alphabet. It leaves out I, L, O and U.
+	CrockfordCharset = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	// NoAmbiguousCharset is upper case letters and digits without 0, O, 1 and I.
+	NoAmbiguousCharset = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+	HexCharset         = "0123456789abcdef"
+	UpperDigitsCharset = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+const maskPlaceholder = 'X'
+
+// CodeNormalizer is implemented by generators whose codes can be typed in more than one way.
+// The handler normalizes the code a user sends with it before comparing it to the stored one.
+type CodeNormalizer interface {
+	Normalize(code string) string
+}
+
+type CharsetGenerator struct {
+	charset string
+	length  int
+	mask    string
+}
+
+// NewCharsetGenerator creates a generator drawing length characters from charset.
+// mask is optional, every X in it is replaced by a generated character and the
+// other characters are kept as separators, e.g. XXXX-XXXX. When mask is set,
+// length can be 0 and will be taken from the mask.
+func NewCharsetGenerator(charset string, length int, mask string) (*CharsetGenerator, error) {
+	if charset == "" {
+		return nil, errors.New("charset is empty")
+	}
+	seen := make(map[rune]bool, len(charset))
+	for _, char := range charset {
+		if char > unicode.MaxASCII || unicode.IsSpace(char) {
+			return nil, fmt.Errorf("charset contains invalid character %q", char)
+		}
+		if seen[char] {
+			return nil, fmt.Errorf("charset contains duplicate character %q", char)
+		}
+		seen[char] = true
+	}
+
+	if mask != "" {
+		placeholders := strings.Count(mask, string(maskPlaceholder))
+		if placeholders == 0 {
+			return nil, errors.New("mask has no X placeholder")
+		}
+		if length == 0 {
+			length = placeholders
+		} else if length != placeholders {
+			return nil, fmt.Errorf("mask has %d placeholders but length is %d", placeholders, length)
+		}
+		for _, char := range mask {
+			if char != maskPlaceholder && seen[char] {
+				return nil, fmt.Errorf("mask separator %q is part of the charset", char)
+			}
+		}
+	}
+
+	if length <= 0 {
+		return nil, errors.New("length must be greater than 0")
+	}
+
+	return &CharsetGenerator{charset: charset, length: length, mask: mask}, nil
+}
+
+func (c *CharsetGenerator) Generate() string {
+	code, _ := c.GenerateE()
+	return code
+}
+
+func (c *CharsetGenerator) GenerateE() (string, error) {
+	result := make([]byte, c.length)
+	for i := 0; i < c.length; i++ {
+		index, err := secureIntn(len(c.charset))
+		if err != nil {
+			return "", err
+		}
+		result[i] = c.charset[index]
+	}
+	return c.format(string(result)), nil
+}
+
+// Normalize maps a typed code to the form Generate produces. Case is folded when
+// the charset has only one case, O is read as 0 and I or L as 1 when the charset
+// has no such letters (Crockford aliases), and spaces or separators are ignored.
+func (c *CharsetGenerator) Normalize(code string) string {
+	var result strings.Builder
+	for _, char := range code {
+		if strings.ContainsRune(c.charset, char) {
+			result.WriteRune(char)
+			continue
+		}
+		if folded, ok := c.fold(char); ok {
+			result.WriteRune(folded)
+			continue
+		}
+		if unicode.IsSpace(char) || char == '-' || (char != maskPlaceholder && strings.ContainsRune(c.mask, char)) {
+			continue
+		}
+		result.WriteRune(char)
+	}
+
+	normalized := result.String()
+	if len(normalized) != c.length {
+		return normalized
+	}
+	return c.format(normalized)
+}
+
+func (c *CharsetGenerator) fold(char rune) (rune, bool) {
+	candidates := []rune{unicode.ToUpper(char), unicode.ToLower(char)}
+	switch unicode.ToUpper(char) {
+	case 'O':
+		candidates = append(candidates, '0')
+	case 'I', 'L':
+		candidates = append(candidates, '1')
+	}
+	for _, candidate := range candidates {
+		if strings.ContainsRune(c.charset, candidate) {
+			return candidate, true
+		}
+	}
+	return char, false
+}
+
+func (c *CharsetGenerator) format(code string) string {
+	if c.mask == "" {
+		return code
+	}
+	var result strings.Builder
+	next := 0
+	for _, char := range c.mask {
+		if char == maskPlaceholder {
+			result.WriteByte(code[next])
+			next++
+			continue
+		}
+		result.WriteRune(char)
+	}
+	return result.String()
+}
+
+func (c *CharsetGenerator) Entropy() float64 {
+	return float64(c.length) * math.Log2(float64(len(c.charset)))
+}
@@ -0,0 +1,93 @@
+package go_verification
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewCharsetGenerator(t *testing.T) {
+	tests := []struct {
+		name    string
+		charset string
+		length  int
+		mask    string
+		wantErr bool
+	}{
+		{"Test valid charset", CrockfordCharset, 8, "", false},
+		{"Test length taken from mask", NoAmbiguousCharset, 0, "XXXX-XXXX", false},
+		{"Test empty charset", "", 8, "", true},
+		{"Test duplicate characters", "AAB", 8, "", true},
+		{"Test zero length", HexCharset, 0, "", true},
+		{"Test mask without placeholder", HexCharset, 0, "----", true},
+		{"Test mask length mismatch", HexCharset, 6, "XXXX-XXXX", true},
+		{"Test separator in charset", "ABC-", 0, "XX-XX", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewCharsetGenerator(tt.charset, tt.length, tt.mask)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, but got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCharsetGeneratorGenerate(t *testing.T) {
+	t.Run("Test characters from charset", func(t *testing.T) {
+		gen, err := NewCharsetGenerator(CrockfordCharset, 12, "")
+		if err != nil {
+			t.Fatalf("NewCharsetGenerator error: %v", err)
+		}
+		result := gen.Generate()
+
+		if len(result) != 12 {
+			t.Errorf("Expected result length of 12, but got %d", len(result))
+		}
+		for _, char := range result {
+			if !strings.ContainsRune(CrockfordCharset, char) {
+				t.Errorf("Expected only charset characters in the result, but got %s", result)
+			}
+		}
+	})
+
+	t.Run("Test mask format", func(t *testing.T) {
+		gen, err := NewCharsetGenerator(NoAmbiguousCharset, 0, "XXXX-XXXX")
+		if err != nil {
+			t.Fatalf("NewCharsetGenerator error: %v", err)
+		}
+		result := gen.Generate()
+
+		if len(result) != 9 || result[4] != '-' {
+			t.Errorf("Expected result in XXXX-XXXX format, but got %s", result)
+		}
+	})
+}
+
+func TestCharsetGeneratorNormalize(t *testing.T) {
+	crockford, _ := NewCharsetGenerator(CrockfordCharset, 0, "XXXX-XXXX")
+	hex, _ := NewCharsetGenerator(HexCharset, 6, "")
+	upper, _ := NewCharsetGenerator(UpperDigitsCharset, 4, "")
+
+	tests := []struct {
+		name      string
+		generator *CharsetGenerator
+		input     string
+		expected  string
+	}{
+		{"Test crockford aliases", crockford, "olI1-abcd", "0111-ABCD"},
+		{"Test missing separator", crockford, "AB12CD34", "AB12-CD34"},
+		{"Test spaces instead of separator", crockford, " ab12 cd34 ", "AB12-CD34"},
+		{"Test lower case charset", hex, "A1B2C3", "a1b2c3"},
+		{"Test no aliases when letter is in charset", upper, "oi0l", "OI0L"},
+		{"Test wrong length is left unformatted", crockford, "AB12", "AB12"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.generator.Normalize(tt.input); result != tt.expected {
+				t.Errorf("Expected %s, but got %s", tt.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+// Command verification inspects and manages verification codes without writing
+// Redis commands by hand.
+//
+//	verification [flags] generate USERNAME SCOPE
+//	verification [flags] get USERNAME SCOPE
+//	verification [flags] check USERNAME SCOPE CODE
+//	verification [flags] delete USERNAME SCOPE
+//	verification [flags] delete-all USERNAME
+//	verification [flags] delete-scope SCOPE
+//	verification [flags] list SCOPE
+//	verification sample SPEC [N]
+//
+// The repository is configured with a config file (see LoadHandlerConfig), the
+// VERIFICATION_* environment variables used with it, or the flags below which override both.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	go_verification "github.com/milito-78/go-verification"
+)
+
+const defaultGenerator = "number:6"
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("verification", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "", "config file, see LoadHandlerConfig")
+	backend := flags.String("backend", go_verification.RedisBackend, "repository backend, redis or memory")
+	addr := flags.String("addr", "localhost:6379", "redis address")
+	password := flags.String("password", "", "redis password")
+	db := flags.Int("db", 0, "redis database")
+	prefix := flags.String("prefix", "verification", "redis key prefix")
+	generator := flags.String("generator", defaultGenerator, "generator spec used by generate")
+	tenant := flags.String("tenant", "", "work on the codes of a tenant only")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "usage: verification [flags] generate|get|check|delete|delete-all|delete-scope|list|sample ...\n\nflags:\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	command, commandArgs := flags.Arg(0), flags.Args()[1:]
+	if command == "sample" {
+		return report(stderr, sample(stdout, commandArgs))
+	}
+
+	config := &go_verification.HandlerConfig{}
+	if *configPath != "" {
+		loaded, err := go_verification.LoadHandlerConfig(*configPath)
+		if err != nil {
+			return report(stderr, err)
+		}
+		config = loaded
+	}
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	override := func(name string, apply func()) {
+		if set[name] || *configPath == "" {
+			apply()
+		}
+	}
+	override("backend", func() { config.Repository.Backend = *backend })
+	override("addr", func() { config.Repository.Redis.Addr = *addr })
+	override("password", func() { config.Repository.Redis.Password = *password })
+	override("db", func() { config.Repository.Redis.DB = *db })
+	override("prefix", func() { config.Repository.Redis.Prefix = *prefix })
+	override("generator", func() { config.Generator = *generator })
+
+	handler, err := go_verification.NewHandlerFromConfig(context.Background(), config)
+	if err != nil {
+		return report(stderr, err)
+	}
+	if *tenant != "" {
+		if handler, err = handler.ForTenant(*tenant); err != nil {
+			return report(stderr, err)
+		}
+	}
+	return report(stderr, execute(handler, command, commandArgs, stdout))
+}
+
+func execute(handler *go_verification.VerificationCodeHandler, command string, args []string, stdout io.Writer) error {
+	switch command {
+	case "generate":
+		if err := expectArgs(args, "USERNAME SCOPE"); err != nil {
+			return err
+		}
+		code, err := handler.GenerateCode(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		printCode(stdout, code)
+	case "get":
+		if err := expectArgs(args, "USERNAME SCOPE"); err != nil {
+			return err
+		}
+		code, err := handler.GetCode(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		printCode(stdout, code)
+	case "check":
+		if err := expectArgs(args, "USERNAME SCOPE CODE"); err != nil {
+			return err
+		}
+		if _, err := handler.CheckCode(args[0], args[2], args[1]); err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, "valid")
+	case "delete":
+		if err := expectArgs(args, "USERNAME SCOPE"); err != nil {
+			return err
+		}
+		if !handler.DeleteCode(args[0], args[1]) {
+			return errors.New("can not delete the code")
+		}
+	case "delete-all":
+		if err := expectArgs(args, "USERNAME"); err != nil {
+			return err
+		}
+		if !handler.Repository().DeleteAllCodes(args[0]) {
+			return errors.New("can not delete the codes")
+		}
+	case "delete-scope":
+		if err := expectArgs(args, "SCOPE"); err != nil {
+			return err
+		}
+		deleted, err := handler.DeleteScope(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "deleted %d codes\n", deleted)
+	case "list":
+		if err := expectArgs(args, "SCOPE"); err != nil {
+			return err
+		}
+		writer := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "USERNAME\tEXPIRES AT\tEXPIRE AFTER")
+		options := go_verification.ListOptions{Scope: args[0]}
+		for {
+			page, err := handler.ListCodes(options)
+			if err != nil {
+				return err
+			}
+			for _, code := range page.Codes {
+				fmt.Fprintf(writer, "%s\t%s\t%ds\n", code.Username, code.ExpiredAt.Format(time.RFC3339), code.ExpireAfter)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			options.Cursor = page.NextCursor
+		}
+		return writer.Flush()
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+	return nil
+}
+
+// sample prints N codes, 10 by default, of a generator spec to preview its format.
+func sample(stdout io.Writer, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: sample SPEC [N]")
+	}
+	generator, err := go_verification.ParseGenerator(args[0])
+	if err != nil {
+		return err
+	}
+	count := 10
+	if len(args) == 2 {
+		if count, err = strconv.Atoi(args[1]); err != nil || count < 1 {
+			return fmt.Errorf("invalid count %q", args[1])
+		}
+	}
+
+	if reporter, ok := generator.(go_verification.EntropyReporter); ok {
+		fmt.Fprintf(stdout, "# %.1f bits of entropy\n", reporter.Entropy())
+	}
+	for i := 0; i < count; i++ {
+		code, err := generate(generator)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, code)
+	}
+	return nil
+}
+
+func generate(generator go_verification.CodeGenerator) (string, error) {
+	if fallible, ok := generator.(go_verification.FallibleGenerator); ok {
+		return fallible.GenerateE()
+	}
+	return generator.Generate(), nil
+}
+
+func expectArgs(args []string, usage string) error {
+	if len(args) != len(strings.Fields(usage)) {
+		return fmt.Errorf("expected %s", usage)
+	}
+	return nil
+}
+
+func printCode(stdout io.Writer, code *go_verification.VerificationCode) {
+	fmt.Fprintf(stdout, "username:     %s\n", code.Username)
+	fmt.Fprintf(stdout, "scope:        %s\n", code.Scope)
+	fmt.Fprintf(stdout, "code:         %s\n", code.Code)
+	fmt.Fprintf(stdout, "expires at:   %s\n", code.ExpiredAt.Format(time.RFC3339))
+	fmt.Fprintf(stdout, "expire after: %ds\n", code.ExpireAfter)
+}
+
+// report prints err and returns the exit code for it.
+func report(stderr io.Writer, err error) int {
+	if err == nil {
+		return 0
+	}
+	fmt.Fprintln(stderr, "error:", err)
+	if errors.Is(err, go_verification.ErrCodeNotFound) || errors.Is(err, go_verification.ErrInvalidCode) || errors.Is(err, go_verification.ErrCodeExpired) {
+		return 1
+	}
+	return 2
+}
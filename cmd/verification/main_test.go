@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	go_verification "github.com/milito-78/go-verification"
+)
+
+func TestRun_Sample(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if status := run([]string{"sample", "number:6", "3"}, &stdout, &stderr); status != 0 {
+		t.Fatalf("Expected status 0, got %d: %s", status, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "#") {
+		t.Fatalf("Expected an entropy line and 3 codes, got %q", stdout.String())
+	}
+	for _, code := range lines[1:] {
+		if len(code) != 6 {
+			t.Errorf("Expected a 6 digit code, got %q", code)
+		}
+	}
+
+	if status := run([]string{"sample", "unknown:6"}, &stdout, &stderr); status != 2 {
+		t.Errorf("Expected status 2 for an invalid spec, got %d", status)
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"no command", nil},
+		{"unknown command", []string{"-backend", "memory", "unknown"}},
+		{"missing arguments", []string{"-backend", "memory", "get", "testuser"}},
+		{"unknown backend", []string{"-backend", "mongo", "get", "testuser", "testscope"}},
+		{"invalid tenant", []string{"-backend", "memory", "-tenant", "a:b", "get", "testuser", "testscope"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if status := run(test.args, &stdout, &stderr); status != 2 {
+				t.Errorf("Expected status 2, got %d", status)
+			}
+			if stderr.Len() == 0 {
+				t.Error("Expected an error message")
+			}
+		})
+	}
+
+	var stdout, stderr bytes.Buffer
+	if status := run([]string{"-backend", "memory", "get", "testuser", "testscope"}, &stdout, &stderr); status != 1 {
+		t.Errorf("Expected status 1 for a missing code, got %d", status)
+	}
+}
+
+func TestExecute(t *testing.T) {
+	generator, err := go_verification.ParseGenerator("number:6")
+	if err != nil {
+		t.Fatalf("ParseGenerator error: %v", err)
+	}
+	handler, err := go_verification.NewVerificationCodeHandler(generator, go_verification.NewMemoryCodeRepository(), &go_verification.Config{
+		ExpiredAfterSec: 5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create VerificationCodeHandler: %v", err)
+	}
+	var stdout bytes.Buffer
+
+	for _, args := range [][]string{{"user1", "testscope"}, {"user2", "testscope"}, {"user1", "otherscope"}} {
+		if err := execute(handler, "generate", args, &stdout); err != nil {
+			t.Fatalf("generate error: %v", err)
+		}
+	}
+
+	stdout.Reset()
+	if err := execute(handler, "list", []string{"testscope"}, &stdout); err != nil {
+		t.Fatalf("list error: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(stdout.String()), "\n"); len(lines) != 3 {
+		t.Errorf("Expected a header and 2 codes, got %q", stdout.String())
+	}
+
+	code, _ := handler.GetCode("user1", "testscope")
+	stdout.Reset()
+	if err := execute(handler, "check", []string{"user1", "testscope", code.Code}, &stdout); err != nil || stdout.String() != "valid\n" {
+		t.Errorf("Expected the code to be valid, got %q, %v", stdout.String(), err)
+	}
+
+	if err := execute(handler, "delete-all", []string{"user1"}, &stdout); err != nil {
+		t.Fatalf("delete-all error: %v", err)
+	}
+	if err := execute(handler, "get", []string{"user1", "otherscope"}, &stdout); err == nil {
+		t.Error("Expected the codes of user1 to be deleted")
+	}
+	if err := execute(handler, "get", []string{"user2", "testscope"}, &stdout); err != nil {
+		t.Errorf("Expected the code of user2 to stay, got %v", err)
+	}
+
+	stdout.Reset()
+	if err := execute(handler, "delete-scope", []string{"testscope"}, &stdout); err != nil || stdout.String() != "deleted 1 codes\n" {
+		t.Errorf("Expected 1 deleted code, got %q, %v", stdout.String(), err)
+	}
+}
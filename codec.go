@@ -0,0 +1,199 @@
+package go_verification
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Record formats of EnvelopeCodec.
+const (
+	JSONFormat   byte = 'j'
+	BinaryFormat byte = 'b'
+)
+
+// CodeSchemaVersion is the version of the VerificationCode fields EnvelopeCodec writes.
+// Bump it when the fields change and keep decoding the previous versions.
+const CodeSchemaVersion byte = 2
+
+// envelopeMagic starts enveloped records, plain JSON records start with '{'.
+const envelopeMagic byte = 0xfe
+
+var ErrUnknownRecordFormat = errors.New("unknown record format")
+
+// Codec encodes the records of RedisCodeRepository. Every codec of this package decodes
+// the records of the others, so switching codecs keeps the stored codes readable.
+type Codec interface {
+	Marshal(code *VerificationCode) ([]byte, error)
+	Unmarshal(data []byte) (*VerificationCode, error)
+}
+
+// KeyedCodec is implemented by a Codec that binds records to the Redis key they are
+// stored under, like EncryptingCodec. RedisCodeRepository uses it when it can.
+type KeyedCodec interface {
+	MarshalKey(key string, code *VerificationCode) ([]byte, error)
+	UnmarshalKey(key string, data []byte) (*VerificationCode, error)
+}
+
+// JSONCodec writes the plain JSON of VerificationCode. It is the default.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(code *VerificationCode) ([]byte, error) {
+	return json.Marshal(code)
+}
+
+func (JSONCodec) Unmarshal(data []byte) (*VerificationCode, error) {
+	return decodeRecord(data)
+}
+
+// EnvelopeCodec writes a header with the format and CodeSchemaVersion before the record.
+type EnvelopeCodec struct {
+	format byte
+}
+
+// NewEnvelopeCodec returns a codec for JSONFormat or BinaryFormat. BinaryFormat records
+// are about a third of the size of JSON ones.
+func NewEnvelopeCodec(format byte) (*EnvelopeCodec, error) {
+	if format != JSONFormat && format != BinaryFormat {
+		return nil, fmt.Errorf("%w %q", ErrUnknownRecordFormat, format)
+	}
+	return &EnvelopeCodec{format: format}, nil
+}
+
+func (e *EnvelopeCodec) Marshal(code *VerificationCode) ([]byte, error) {
+	data := []byte{envelopeMagic, e.format, CodeSchemaVersion}
+	if e.format == BinaryFormat {
+		return appendBinaryRecord(data, code), nil
+	}
+	payload, err := json.Marshal(code)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, payload...), nil
+}
+
+func (e *EnvelopeCodec) Unmarshal(data []byte) (*VerificationCode, error) {
+	return decodeRecord(data)
+}
+
+func decodeRecord(data []byte) (*VerificationCode, error) {
+	if len(data) == 0 || data[0] != envelopeMagic {
+		var code VerificationCode
+		if err := json.Unmarshal(data, &code); err != nil {
+			return nil, err
+		}
+		return &code, nil
+	}
+
+	if len(data) < 3 {
+		return nil, errors.New("truncated record header")
+	}
+	format, version, payload := data[1], data[2], data[3:]
+	if version == 0 || version > CodeSchemaVersion {
+		return nil, fmt.Errorf("record schema version %d is not supported, max is %d", version, CodeSchemaVersion)
+	}
+	switch format {
+	case JSONFormat:
+		var code VerificationCode
+		if err := json.Unmarshal(payload, &code); err != nil {
+			return nil, err
+		}
+		return &code, nil
+	case BinaryFormat:
+		return decodeBinaryRecord(payload, version)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownRecordFormat, format)
+	}
+}
+
+// appendBinaryRecord writes ExpiredAt and ExpiredTime as varint nanoseconds, then
+// Username, Scope and Code as length-prefixed strings. Since version 2 they are followed
+// by the number of Metadata entries and their sorted keys and values. ExpireAfter is not
+// stored, the repositories compute it when reading.
+func appendBinaryRecord(data []byte, code *VerificationCode) []byte {
+	data = binary.AppendVarint(data, code.ExpiredAt.UnixNano())
+	data = binary.AppendVarint(data, int64(code.ExpiredTime))
+	for _, field := range []string{code.Username, code.Scope, code.Code} {
+		data = appendBinaryString(data, field)
+	}
+
+	keys := make([]string, 0, len(code.Metadata))
+	for key := range code.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	data = binary.AppendUvarint(data, uint64(len(keys)))
+	for _, key := range keys {
+		data = appendBinaryString(data, key)
+		data = appendBinaryString(data, code.Metadata[key])
+	}
+	return data
+}
+
+func appendBinaryString(data []byte, s string) []byte {
+	data = binary.AppendUvarint(data, uint64(len(s)))
+	return append(data, s...)
+}
+
+func decodeBinaryRecord(data []byte, version byte) (*VerificationCode, error) {
+	errTruncated := errors.New("truncated binary record")
+	expiredAt, n := binary.Varint(data)
+	if n <= 0 {
+		return nil, errTruncated
+	}
+	data = data[n:]
+	expiredTime, n := binary.Varint(data)
+	if n <= 0 {
+		return nil, errTruncated
+	}
+	data = data[n:]
+
+	readString := func() (string, bool) {
+		length, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < length {
+			return "", false
+		}
+		s := string(data[n : n+int(length)])
+		data = data[n+int(length):]
+		return s, true
+	}
+	var fields [3]string
+	for i := range fields {
+		var ok bool
+		if fields[i], ok = readString(); !ok {
+			return nil, errTruncated
+		}
+	}
+	code := &VerificationCode{
+		ExpiredAt:   time.Unix(0, expiredAt),
+		ExpiredTime: Duration(expiredTime),
+		Username:    fields[0],
+		Scope:       fields[1],
+		Code:        fields[2],
+	}
+	if version < 2 {
+		return code, nil
+	}
+
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)) {
+		return nil, errTruncated
+	}
+	data = data[n:]
+	if count > 0 {
+		code.Metadata = make(map[string]string, count)
+	}
+	for i := uint64(0); i < count; i++ {
+		key, ok := readString()
+		if !ok {
+			return nil, errTruncated
+		}
+		if code.Metadata[key], ok = readString(); !ok {
+			return nil, errTruncated
+		}
+	}
+	return code, nil
+}
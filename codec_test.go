@@ -0,0 +1,93 @@
+package go_verification
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testRecord() *VerificationCode {
+	return &VerificationCode{
+		ExpiredAt:   time.Unix(1700000000, 123000000),
+		ExpiredTime: Duration(3 * time.Minute),
+		Username:    "user@example.com",
+		Scope:       "forget-password",
+		Code:        "123456",
+		Metadata:    map[string]string{"email": "new@example.com", "order": "42"},
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	jsonEnvelope, _ := NewEnvelopeCodec(JSONFormat)
+	binaryEnvelope, _ := NewEnvelopeCodec(BinaryFormat)
+	codecs := map[string]Codec{"json": JSONCodec{}, "envelope-json": jsonEnvelope, "binary": binaryEnvelope}
+
+	for name, codec := range codecs {
+		t.Run(name, func(t *testing.T) {
+			data, err := codec.Marshal(testRecord())
+			if err != nil {
+				t.Fatalf("Marshal error: %v", err)
+			}
+			for other, decoder := range codecs {
+				decoded, err := decoder.Unmarshal(data)
+				if err != nil {
+					t.Fatalf("Unmarshal with %s error: %v", other, err)
+				}
+				expected := testRecord()
+				if !decoded.ExpiredAt.Equal(expected.ExpiredAt) || decoded.ExpiredTime != expected.ExpiredTime ||
+					decoded.Username != expected.Username || decoded.Scope != expected.Scope || decoded.Code != expected.Code ||
+					!sameMetadata(decoded.Metadata, expected.Metadata) {
+					t.Errorf("Unmarshal with %s: expected %+v, but got %+v", other, expected, decoded)
+				}
+			}
+		})
+	}
+
+	jsonData, _ := JSONCodec{}.Marshal(testRecord())
+	binaryData, _ := binaryEnvelope.Marshal(testRecord())
+	if len(binaryData) >= len(jsonData)/2 {
+		t.Errorf("Expected binary records to be much smaller, got %d bytes against %d", len(binaryData), len(jsonData))
+	}
+}
+
+func TestCodecs_BinaryVersion1(t *testing.T) {
+	record := testRecord()
+	record.Metadata = nil
+	data := appendBinaryRecord([]byte{envelopeMagic, BinaryFormat, 1}, record)
+	// version 1 records end after the code, without the metadata count
+	decoded, err := decodeRecord(data[:len(data)-1])
+	if err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if decoded.Code != "123456" || decoded.Metadata != nil {
+		t.Errorf("Expected the code without metadata, got %+v", decoded)
+	}
+}
+
+func TestCodecs_Errors(t *testing.T) {
+	if _, err := NewEnvelopeCodec('x'); !errors.Is(err, ErrUnknownRecordFormat) {
+		t.Errorf("Expected ErrUnknownRecordFormat, got %v", err)
+	}
+
+	binaryEnvelope, _ := NewEnvelopeCodec(BinaryFormat)
+	data, _ := binaryEnvelope.Marshal(testRecord())
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"truncated header", data[:2], "truncated"},
+		{"truncated payload", data[:len(data)-3], "truncated"},
+		{"newer version", append([]byte{envelopeMagic, BinaryFormat, CodeSchemaVersion + 1}, data[3:]...), "not supported"},
+		{"unknown format", append([]byte{envelopeMagic, 'x', CodeSchemaVersion}, data[3:]...), "unknown record format"},
+		{"invalid json", []byte("{"), "unexpected end"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := binaryEnvelope.Unmarshal(test.data); err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("Expected an error about %q, got %v", test.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package go_verification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigEnvPrefix is the prefix of the environment variables LoadHandlerConfig reads.
+const DefaultConfigEnvPrefix = "VERIFICATION_"
+
+const (
+	RedisBackend  = "redis"
+	MemoryBackend = "memory"
+)
+
+// HandlerConfig describes a whole VerificationCodeHandler. It can be decoded from JSON or
+// YAML.
+type HandlerConfig struct {
+	Repository          RepositoryConfig       `json:"repository" yaml:"repository"`
+	Generator           string                 `json:"generator" yaml:"generator"`
+	ExpiredAfter        Duration               `json:"expired_after" yaml:"expired_after"`
+	MaxAttempts         int                    `json:"max_attempts" yaml:"max_attempts"`
+	MaxGuessProbability float64                `json:"max_guess_probability" yaml:"max_guess_probability"`
+	Scopes              map[string]ScopeConfig `json:"scopes" yaml:"scopes"`
+}
+
+type RepositoryConfig struct {
+	// Backend is redis or memory.
+	Backend string      `json:"backend" yaml:"backend"`
+	Redis   RedisConfig `json:"redis" yaml:"redis"`
+	// Codec of the redis records is json, envelope-json or binary. Empty is json.
+	Codec string `json:"codec" yaml:"codec"`
+}
+
+// redisCodecs are the codec names of RepositoryConfig.
+var redisCodecs = map[string]func() Codec{
+	"json": func() Codec { return JSONCodec{} },
+	"envelope-json": func() Codec {
+		codec, _ := NewEnvelopeCodec(JSONFormat)
+		return codec
+	},
+	"binary": func() Codec {
+		codec, _ := NewEnvelopeCodec(BinaryFormat)
+		return codec
+	},
+}
+
+type ScopeConfig struct {
+	ExpiredAfter Duration `json:"expired_after" yaml:"expired_after"`
+}
+
+// ConfigError lists every problem found in a HandlerConfig.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid verification config:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+func (e *ConfigError) add(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+func (e *ConfigError) err() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+	return e
+}
+
+// LoadHandlerConfig reads a config file, YAML when it ends with .yaml or .yml and JSON
+// otherwise, applies the environment variables with DefaultConfigEnvPrefix on top of it
+// and validates the result. path can be empty to configure everything from the environment.
+func LoadHandlerConfig(path string) (*HandlerConfig, error) {
+	config := &HandlerConfig{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := decodeHandlerConfig(path, data, config); err != nil {
+			return nil, fmt.Errorf("can not decode %s: %w", path, err)
+		}
+	}
+
+	problems := &ConfigError{}
+	for _, err := range []error{config.ApplyEnv(DefaultConfigEnvPrefix), config.Validate()} {
+		var configErr *ConfigError
+		if errors.As(err, &configErr) {
+			problems.Problems = append(problems.Problems, configErr.Problems...)
+		}
+	}
+	if err := problems.err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// decodeHandlerConfig decodes data by the extension of path, unknown fields are errors.
+func decodeHandlerConfig(path string, data []byte, config *HandlerConfig) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		return decoder.Decode(config)
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		return decoder.Decode(config)
+	}
+}
+
+// ApplyEnv overrides the config with these environment variables when they are set:
+// BACKEND, REDIS_ADDR, REDIS_PASSWORD, REDIS_DB, REDIS_PREFIX, CODEC, GENERATOR, EXPIRED_AFTER,
+// MAX_ATTEMPTS and MAX_GUESS_PROBABILITY, each one with prefix before it.
+func (c *HandlerConfig) ApplyEnv(prefix string) error {
+	problems := &ConfigError{}
+	lookup := func(name string, apply func(value string) error) {
+		value, ok := os.LookupEnv(prefix + name)
+		if !ok {
+			return
+		}
+		if err := apply(value); err != nil {
+			problems.add("%s%s: %s", prefix, name, err)
+		}
+	}
+
+	lookup("BACKEND", func(value string) error {
+		c.Repository.Backend = value
+		return nil
+	})
+	lookup("REDIS_ADDR", func(value string) error {
+		c.Repository.Redis.Addr = value
+		return nil
+	})
+	lookup("REDIS_PASSWORD", func(value string) error {
+		c.Repository.Redis.Password = value
+		return nil
+	})
+	lookup("REDIS_DB", func(value string) (err error) {
+		c.Repository.Redis.DB, err = strconv.Atoi(value)
+		return err
+	})
+	lookup("REDIS_PREFIX", func(value string) error {
+		c.Repository.Redis.Prefix = value
+		return nil
+	})
+	lookup("CODEC", func(value string) error {
+		c.Repository.Codec = value
+		return nil
+	})
+	lookup("GENERATOR", func(value string) error {
+		c.Generator = value
+		return nil
+	})
+	lookup("EXPIRED_AFTER", func(value string) error {
+		expiredAfter, err := time.ParseDuration(value)
+		c.ExpiredAfter = Duration(expiredAfter)
+		return err
+	})
+	lookup("MAX_ATTEMPTS", func(value string) (err error) {
+		c.MaxAttempts, err = strconv.Atoi(value)
+		return err
+	})
+	lookup("MAX_GUESS_PROBABILITY", func(value string) (err error) {
+		c.MaxGuessProbability, err = strconv.ParseFloat(value, 64)
+		return err
+	})
+
+	return problems.err()
+}
+
+// Validate checks the whole config and returns a *ConfigError with every problem found.
+func (c *HandlerConfig) Validate() error {
+	problems := &ConfigError{}
+
+	switch c.Repository.Backend {
+	case RedisBackend:
+		if c.Repository.Redis.Addr == "" {
+			problems.add("repository.redis.addr is required for the redis backend")
+		}
+		if c.Repository.Redis.DB < 0 {
+			problems.add("repository.redis.db can not be negative")
+		}
+	case MemoryBackend:
+	case "":
+		problems.add("repository.backend is required, use %s or %s", RedisBackend, MemoryBackend)
+	default:
+		problems.add("repository.backend %q is unknown, use %s or %s", c.Repository.Backend, RedisBackend, MemoryBackend)
+	}
+
+	if _, ok := redisCodecs[c.Repository.Codec]; !ok && c.Repository.Codec != "" {
+		problems.add("repository.codec %q is unknown, use json, envelope-json or binary", c.Repository.Codec)
+	}
+
+	if c.MaxAttempts < 0 {
+		problems.add("max_attempts can not be negative")
+	}
+	if c.MaxGuessProbability < 0 || c.MaxGuessProbability >= 1 {
+		problems.add("max_guess_probability must be between 0 and 1")
+	}
+
+	if c.Generator == "" {
+		problems.add("generator is required")
+	} else if generator, err := ParseGenerator(c.Generator); err != nil {
+		problems.add("generator: %s", err)
+	} else if err := checkGeneratorStrength(generator, c.config()); err != nil {
+		problems.add("generator: %s", err)
+	}
+
+	c.checkExpiredAfter(problems, "expired_after", c.ExpiredAfter)
+	scopes := make([]string, 0, len(c.Scopes))
+	for scope := range c.Scopes {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	for _, scope := range scopes {
+		if scope == "" {
+			problems.add("scopes: scope name can not be empty")
+		}
+		c.checkExpiredAfter(problems, "scopes."+scope+".expired_after", c.Scopes[scope].ExpiredAfter)
+	}
+
+	return problems.err()
+}
+
+func (c *HandlerConfig) checkExpiredAfter(problems *ConfigError, field string, expiredAfter Duration) {
+	if expiredAfter != 0 && (time.Duration(expiredAfter) < time.Minute || time.Duration(expiredAfter) > 10*time.Minute) {
+		problems.add("%s must be between 1m and 10m, got %s", field, time.Duration(expiredAfter))
+	}
+}
+
+func (c *HandlerConfig) config() *Config {
+	config := &Config{
+		ExpiredAfterSec:     time.Duration(c.ExpiredAfter),
+		MaxAttempts:         c.MaxAttempts,
+		MaxGuessProbability: c.MaxGuessProbability,
+	}
+	if len(c.Scopes) > 0 {
+		config.Scopes = make(map[string]ScopePolicy, len(c.Scopes))
+		for scope, policy := range c.Scopes {
+			config.Scopes[scope] = ScopePolicy{ExpiredAfterSec: time.Duration(policy.ExpiredAfter)}
+		}
+	}
+	return config
+}
+
+// NewHandlerFromConfig validates config and builds the handler it describes.
+func NewHandlerFromConfig(ctx context.Context, config *HandlerConfig) (*VerificationCodeHandler, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	generator, err := ParseGenerator(config.Generator)
+	if err != nil {
+		return nil, err
+	}
+
+	var repository CodeRepositoryInterface
+	switch config.Repository.Backend {
+	case RedisBackend:
+		redisConfig := config.Repository.Redis
+		if config.Repository.Codec != "" {
+			redisConfig.Codec = redisCodecs[config.Repository.Codec]()
+		}
+		repository, err = ConnectRedisCodeRepository(ctx, redisConfig)
+		if err != nil {
+			return nil, err
+		}
+	case MemoryBackend:
+		repository = NewMemoryCodeRepository()
+	}
+
+	return NewVerificationCodeHandler(generator, repository, config.config())
+}
+
+// LoadHandler loads the config with LoadHandlerConfig and builds its handler.
+func LoadHandler(ctx context.Context, path string) (*VerificationCodeHandler, error) {
+	config, err := LoadHandlerConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewHandlerFromConfig(ctx, config)
+}
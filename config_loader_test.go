@@ -0,0 +1,160 @@
+package go_verification
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	return writeConfigFileNamed(t, "verification.json", content)
+}
+
+func writeConfigFileNamed(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+	return path
+}
+
+func TestLoadHandlerConfig(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"repository": {"backend": "redis", "redis": {"addr": "localhost:6379", "prefix": "verification"}},
+		"generator": "number:6,nozero",
+		"expired_after": "3m",
+		"max_attempts": 5,
+		"max_guess_probability": 0.0001,
+		"scopes": {"forget-password": {"expired_after": "10m"}}
+	}`)
+	t.Setenv("VERIFICATION_REDIS_DB", "2")
+	t.Setenv("VERIFICATION_GENERATOR", "charset:crockford:8")
+
+	config, err := LoadHandlerConfig(path)
+	if err != nil {
+		t.Fatalf("LoadHandlerConfig error: %v", err)
+	}
+
+	if config.Repository.Redis.Addr != "localhost:6379" || config.Repository.Redis.Prefix != "verification" {
+		t.Errorf("Unexpected redis config %+v", config.Repository.Redis)
+	}
+	if config.Repository.Redis.DB != 2 {
+		t.Errorf("Expected DB from the environment, got %d", config.Repository.Redis.DB)
+	}
+	if config.Generator != "charset:crockford:8" {
+		t.Errorf("Expected generator from the environment, got %s", config.Generator)
+	}
+	if time.Duration(config.ExpiredAfter) != 3*time.Minute {
+		t.Errorf("Expected 3m, got %s", time.Duration(config.ExpiredAfter))
+	}
+	if time.Duration(config.Scopes["forget-password"].ExpiredAfter) != 10*time.Minute {
+		t.Errorf("Expected scope expiration of 10m, got %v", config.Scopes)
+	}
+}
+
+func TestLoadHandlerConfig_YAML(t *testing.T) {
+	path := writeConfigFileNamed(t, "verification.yaml", `
+repository:
+  backend: memory
+generator: "number:6,nozero"
+expired_after: 3m
+scopes:
+  forget-password:
+    expired_after: 10m
+`)
+
+	config, err := LoadHandlerConfig(path)
+	if err != nil {
+		t.Fatalf("LoadHandlerConfig error: %v", err)
+	}
+	if config.Repository.Backend != MemoryBackend || config.Generator != "number:6,nozero" {
+		t.Errorf("Unexpected config %+v", config)
+	}
+	if time.Duration(config.Scopes["forget-password"].ExpiredAfter) != 10*time.Minute {
+		t.Errorf("Expected scope expiration of 10m, got %v", config.Scopes)
+	}
+
+	path = writeConfigFileNamed(t, "verification.yml", "repository:\n  backend: memory\ngenerator: number:6\nexpire_after: 2m\n")
+	if _, err := LoadHandlerConfig(path); err == nil || !strings.Contains(err.Error(), "expire_after") {
+		t.Errorf("Expected an error about the unknown field, got %v", err)
+	}
+}
+
+func TestLoadHandlerConfig_Problems(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"repository": {"backend": "redis", "codec": "xml"},
+		"generator": "number:2",
+		"expired_after": "30m",
+		"max_guess_probability": 0.001,
+		"scopes": {"login": {"expired_after": "5s"}}
+	}`)
+	t.Setenv("VERIFICATION_MAX_ATTEMPTS", "many")
+
+	_, err := LoadHandlerConfig(path)
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) {
+		t.Fatalf("Expected a ConfigError, got %v", err)
+	}
+	expected := []string{"VERIFICATION_MAX_ATTEMPTS", "repository.redis.addr", "repository.codec", "generator is too weak", "expired_after must be", "scopes.login.expired_after"}
+	if len(configErr.Problems) != len(expected) {
+		t.Fatalf("Expected %d problems, got %v", len(expected), configErr.Problems)
+	}
+	for i, problem := range expected {
+		if !strings.Contains(configErr.Problems[i], problem) {
+			t.Errorf("Expected problem about %q, got %q", problem, configErr.Problems[i])
+		}
+	}
+}
+
+func TestLoadHandlerConfig_UnknownField(t *testing.T) {
+	path := writeConfigFile(t, `{"repository": {"backend": "memory"}, "generator": "number:6", "expire_after": "2m"}`)
+
+	if _, err := LoadHandlerConfig(path); err == nil || !strings.Contains(err.Error(), "expire_after") {
+		t.Errorf("Expected an error about the unknown field, got %v", err)
+	}
+}
+
+func TestLoadHandler(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"repository": {"backend": "memory"},
+		"generator": "number:6",
+		"expired_after": "2m",
+		"scopes": {"forget-password": {"expired_after": "10m"}}
+	}`)
+
+	handler, err := LoadHandler(context.Background(), path)
+	if err != nil {
+		t.Fatalf("LoadHandler error: %v", err)
+	}
+
+	code, err := handler.GenerateCode("testuser", "forget-password")
+	if err != nil {
+		t.Fatalf("GenerateCode error: %v", err)
+	}
+	if time.Duration(code.ExpiredTime) != 10*time.Minute {
+		t.Errorf("Expected the scope expiration, got %s", time.Duration(code.ExpiredTime))
+	}
+
+	code, err = handler.GenerateCode("testuser", "login")
+	if err != nil {
+		t.Fatalf("GenerateCode error: %v", err)
+	}
+	if time.Duration(code.ExpiredTime) != 2*time.Minute {
+		t.Errorf("Expected the default expiration, got %s", time.Duration(code.ExpiredTime))
+	}
+}
+
+func TestNewHandlerFromConfig_RedisUnreachable(t *testing.T) {
+	config := &HandlerConfig{
+		Repository: RepositoryConfig{Backend: RedisBackend, Redis: RedisConfig{Addr: "127.0.0.1:1"}},
+		Generator:  "number:6",
+	}
+
+	if _, err := NewHandlerFromConfig(context.Background(), config); err == nil {
+		t.Error("Expected an error when redis is unreachable")
+	}
+}
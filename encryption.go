@@ -0,0 +1,535 @@
+package go_verification
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	crand "crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// encryptedMagic starts sealed records, enveloped ones start with envelopeMagic.
+const encryptedMagic byte = 0xfd
+
+// encryptedCodePrefix marks the codes sealed by EncryptedCodeRepository.
+const encryptedCodePrefix = "enc1:"
+
+// encryptedMetadataKey is the only metadata key of codes sealed by EncryptedCodeRepository,
+// its value is the whole metadata sealed like the code.
+const encryptedMetadataKey = "enc1"
+
+var (
+	ErrUnknownEncryptionKey = errors.New("unknown encryption key")
+	ErrDecryptionFailed     = errors.New("can not decrypt record")
+)
+
+// EncryptionKeys seals records with AES-GCM under the current key and opens them with
+// the key whose ID is in the record, so old keys can be kept for reading while rotating.
+type EncryptionKeys struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewEncryptionKeys takes AES keys of 16, 24 or 32 bytes by ID. current is the ID new
+// records are sealed with.
+func NewEncryptionKeys(current string, keys map[string][]byte) (*EncryptionKeys, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current key %q is missing", current)
+	}
+	e := &EncryptionKeys{current: current, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("key ID %q must be 1 to 255 bytes", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		e.keys[id] = aead
+	}
+	return e, nil
+}
+
+// seal returns the magic byte, the key ID and a random nonce followed by the ciphertext.
+// The header and data are authenticated as associated data.
+func (e *EncryptionKeys) seal(plaintext, data []byte) ([]byte, error) {
+	aead := e.keys[e.current]
+	header := append([]byte{encryptedMagic, byte(len(e.current))}, e.current...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := crand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := append(append([]byte{}, header...), nonce...)
+	return aead.Seal(sealed, nonce, plaintext, append(header, data...)), nil
+}
+
+func (e *EncryptionKeys) open(sealed, data []byte) ([]byte, error) {
+	if len(sealed) < 2 || sealed[0] != encryptedMagic || len(sealed) < 2+int(sealed[1]) {
+		return nil, ErrDecryptionFailed
+	}
+	headerSize := 2 + int(sealed[1])
+	id := string(sealed[2:headerSize])
+	aead, ok := e.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownEncryptionKey, id)
+	}
+	if len(sealed) < headerSize+aead.NonceSize() {
+		return nil, ErrDecryptionFailed
+	}
+	header := sealed[:headerSize:headerSize]
+	nonce, ciphertext := sealed[headerSize:headerSize+aead.NonceSize()], sealed[headerSize+aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, append(header, data...))
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+// EncryptingCodec seals the records of another codec, usernames included. Records
+// written before encryption was enabled are still read. RedisCodeRepository seals them
+// with their key as associated data, so a record can't be copied under another key.
+type EncryptingCodec struct {
+	codec Codec
+	keys  *EncryptionKeys
+}
+
+// NewEncryptingCodec wraps codec, JSONCodec when it is nil.
+func NewEncryptingCodec(codec Codec, keys *EncryptionKeys) *EncryptingCodec {
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+	return &EncryptingCodec{codec: codec, keys: keys}
+}
+
+// Marshal seals code without associated data, RedisCodeRepository uses MarshalKey.
+func (e *EncryptingCodec) Marshal(code *VerificationCode) ([]byte, error) {
+	return e.MarshalKey("", code)
+}
+
+func (e *EncryptingCodec) Unmarshal(data []byte) (*VerificationCode, error) {
+	return e.UnmarshalKey("", data)
+}
+
+// MarshalKey seals code with key as associated data.
+func (e *EncryptingCodec) MarshalKey(key string, code *VerificationCode) ([]byte, error) {
+	data, err := e.codec.Marshal(code)
+	if err != nil {
+		return nil, err
+	}
+	return e.keys.seal(data, []byte(key))
+}
+
+func (e *EncryptingCodec) UnmarshalKey(key string, data []byte) (*VerificationCode, error) {
+	if len(data) == 0 || data[0] != encryptedMagic {
+		return e.codec.Unmarshal(data)
+	}
+	plaintext, err := e.keys.open(data, []byte(key))
+	if err != nil {
+		return nil, err
+	}
+	return e.codec.Unmarshal(plaintext)
+}
+
+// EncryptedCodeRepository seals the codes and metadata of any repository before they are
+// saved. The username and scope are the associated data, so a sealed code can't be moved
+// to another record. Usernames are used by the repository to find codes and stay readable,
+// use an EncryptingCodec to seal whole Redis records. The optional stores of the wrapped
+// repository are forwarded, the ones it lacks fail, see Unwrap.
+type EncryptedCodeRepository struct {
+	CodeRepositoryInterface
+	keys *EncryptionKeys
+}
+
+func NewEncryptedCodeRepository(repository CodeRepositoryInterface, keys *EncryptionKeys) *EncryptedCodeRepository {
+	return &EncryptedCodeRepository{CodeRepositoryInterface: repository, keys: keys}
+}
+
+// Unwrap returns the wrapped repository, the handler checks it for the optional stores
+// its configuration needs.
+func (e *EncryptedCodeRepository) Unwrap() CodeRepositoryInterface {
+	return e.CodeRepositoryInterface
+}
+
+func (e *EncryptedCodeRepository) SaveCode(username, code, scope string, expiresTime time.Duration) (*VerificationCode, error) {
+	sealed, err := e.seal(username, code, scope)
+	if err != nil {
+		return nil, err
+	}
+	verification, err := e.CodeRepositoryInterface.SaveCode(username, sealed, scope, expiresTime)
+	if err != nil {
+		return nil, err
+	}
+	verification.Code = code
+	return verification, nil
+}
+
+// SaveCodeWithMetadata seals the code and the metadata, the metadata is saved under a
+// single key.
+func (e *EncryptedCodeRepository) SaveCodeWithMetadata(username, code, scope string, expiresTime time.Duration, metadata map[string]string) (*VerificationCode, error) {
+	saver, ok := e.CodeRepositoryInterface.(MetadataSaver)
+	if !ok {
+		return nil, ErrMetadataUnsupported
+	}
+	sealed, err := e.seal(username, code, scope)
+	if err != nil {
+		return nil, err
+	}
+	sealedMetadata, err := e.sealMetadata(username, scope, metadata)
+	if err != nil {
+		return nil, err
+	}
+	verification, err := saver.SaveCodeWithMetadata(username, sealed, scope, expiresTime, sealedMetadata)
+	if err != nil {
+		return nil, err
+	}
+	verification.Code = code
+	verification.Metadata = copyMetadata(metadata)
+	return verification, nil
+}
+
+func (e *EncryptedCodeRepository) GetCode(username, scope string) (*VerificationCode, error) {
+	verification, err := e.CodeRepositoryInterface.GetCode(username, scope)
+	if err != nil {
+		return nil, err
+	}
+	return e.open(verification, username, scope)
+}
+
+// ListCodes lists the codes of the wrapped repository, ErrListingUnsupported when it
+// doesn't implement CodeLister.
+func (e *EncryptedCodeRepository) ListCodes(options ListOptions) (*CodePage, error) {
+	lister, ok := e.CodeRepositoryInterface.(CodeLister)
+	if !ok {
+		return nil, ErrListingUnsupported
+	}
+	page, err := lister.ListCodes(options)
+	if err != nil {
+		return nil, err
+	}
+	for i, code := range page.Codes {
+		if page.Codes[i], err = e.open(code, code.Username, code.Scope); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// WithTenant encrypts the codes of a tenant of the wrapped repository.
+func (e *EncryptedCodeRepository) WithTenant(tenant string) (CodeRepositoryInterface, error) {
+	tenants, ok := e.CodeRepositoryInterface.(TenantRepository)
+	if !ok {
+		return nil, ErrTenantsUnsupported
+	}
+	repository, err := tenants.WithTenant(tenant)
+	if err != nil {
+		return nil, err
+	}
+	return NewEncryptedCodeRepository(repository, e.keys), nil
+}
+
+// SaveCodeIfAbsent seals the code like SaveCode, the code that won is opened. Wrapped
+// repositories without ConditionalSaver save it unconditionally.
+func (e *EncryptedCodeRepository) SaveCodeIfAbsent(username, code, scope string, expiresTime time.Duration, metadata map[string]string) (*VerificationCode, error) {
+	saver, ok := e.CodeRepositoryInterface.(ConditionalSaver)
+	if !ok {
+		if len(metadata) == 0 {
+			return e.SaveCode(username, code, scope, expiresTime)
+		}
+		return e.SaveCodeWithMetadata(username, code, scope, expiresTime, metadata)
+	}
+	sealed, err := e.seal(username, code, scope)
+	if err != nil {
+		return nil, err
+	}
+	sealedMetadata, err := e.sealMetadata(username, scope, metadata)
+	if err != nil {
+		return nil, err
+	}
+	winner, err := saver.SaveCodeIfAbsent(username, sealed, scope, expiresTime, sealedMetadata)
+	if err != nil {
+		return nil, err
+	}
+	return e.open(winner, username, scope)
+}
+
+// PushPreviousCode seals the code like SaveCode before the wrapped repository keeps it.
+func (e *EncryptedCodeRepository) PushPreviousCode(code *VerificationCode, limit int) error {
+	store, ok := e.CodeRepositoryInterface.(PreviousCodeStore)
+	if !ok {
+		return errors.New("repository does not support several valid codes")
+	}
+	previous, err := e.sealCode(code)
+	if err != nil {
+		return err
+	}
+	return store.PushPreviousCode(previous, limit)
+}
+
+func (e *EncryptedCodeRepository) GetPreviousCodes(username, scope string) ([]*VerificationCode, error) {
+	store, ok := e.CodeRepositoryInterface.(PreviousCodeStore)
+	if !ok {
+		return nil, errors.New("repository does not support several valid codes")
+	}
+	codes, err := store.GetPreviousCodes(username, scope)
+	if err != nil {
+		return nil, err
+	}
+	for i, code := range codes {
+		if codes[i], err = e.open(code, username, scope); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+func (e *EncryptedCodeRepository) DeletePreviousCodes(username, scope string) error {
+	store, ok := e.CodeRepositoryInterface.(PreviousCodeStore)
+	if !ok {
+		return errors.New("repository does not support several valid codes")
+	}
+	return store.DeletePreviousCodes(username, scope)
+}
+
+// TakeResend forwards to the wrapped repository, it must implement ResendStore.
+func (e *EncryptedCodeRepository) TakeResend(username, scope string, backoff ResendBackoff) (time.Time, error) {
+	store, ok := e.CodeRepositoryInterface.(ResendStore)
+	if !ok {
+		return time.Time{}, errors.New("repository does not support resend backoff")
+	}
+	return store.TakeResend(username, scope, backoff)
+}
+
+func (e *EncryptedCodeRepository) ReleaseResend(username, scope string, next time.Time) error {
+	store, ok := e.CodeRepositoryInterface.(ResendStore)
+	if !ok {
+		return errors.New("repository does not support resend backoff")
+	}
+	return store.ReleaseResend(username, scope, next)
+}
+
+func (e *EncryptedCodeRepository) ResetResend(username, scope string) error {
+	store, ok := e.CodeRepositoryInterface.(ResendStore)
+	if !ok {
+		return errors.New("repository does not support resend backoff")
+	}
+	return store.ResetResend(username, scope)
+}
+
+// SaveGrant forwards to the wrapped repository, it must implement GrantStore. Grants hold
+// no code.
+func (e *EncryptedCodeRepository) SaveGrant(id string, grant *Grant, ttl time.Duration) error {
+	store, ok := e.CodeRepositoryInterface.(GrantStore)
+	if !ok {
+		return errors.New("repository does not support grants")
+	}
+	return store.SaveGrant(id, grant, ttl)
+}
+
+func (e *EncryptedCodeRepository) GetGrant(id string) (*Grant, error) {
+	store, ok := e.CodeRepositoryInterface.(GrantStore)
+	if !ok {
+		return nil, errors.New("repository does not support grants")
+	}
+	return store.GetGrant(id)
+}
+
+func (e *EncryptedCodeRepository) TakeGrant(id string) (*Grant, error) {
+	store, ok := e.CodeRepositoryInterface.(GrantStore)
+	if !ok {
+		return nil, errors.New("repository does not support grants")
+	}
+	return store.TakeGrant(id)
+}
+
+// SaveRecoveryCodes forwards to the wrapped repository, it must implement
+// RecoveryCodeStore. Recovery codes are already hashed.
+func (e *EncryptedCodeRepository) SaveRecoveryCodes(username string, hashes []string) error {
+	store, ok := e.CodeRepositoryInterface.(RecoveryCodeStore)
+	if !ok {
+		return errors.New("repository does not support recovery codes")
+	}
+	return store.SaveRecoveryCodes(username, hashes)
+}
+
+func (e *EncryptedCodeRepository) BurnRecoveryCode(username, hash string) (bool, error) {
+	store, ok := e.CodeRepositoryInterface.(RecoveryCodeStore)
+	if !ok {
+		return false, errors.New("repository does not support recovery codes")
+	}
+	return store.BurnRecoveryCode(username, hash)
+}
+
+func (e *EncryptedCodeRepository) CountRecoveryCodes(username string) (int, error) {
+	store, ok := e.CodeRepositoryInterface.(RecoveryCodeStore)
+	if !ok {
+		return 0, errors.New("repository does not support recovery codes")
+	}
+	return store.CountRecoveryCodes(username)
+}
+
+func (e *EncryptedCodeRepository) DeleteRecoveryCodes(username string) error {
+	store, ok := e.CodeRepositoryInterface.(RecoveryCodeStore)
+	if !ok {
+		return errors.New("repository does not support recovery codes")
+	}
+	return store.DeleteRecoveryCodes(username)
+}
+
+// ReserveEvent forwards to the wrapped repository, it must implement VelocityStore.
+func (e *EncryptedCodeRepository) ReserveEvent(key string, window time.Duration) (string, int, time.Time, error) {
+	store, ok := e.CodeRepositoryInterface.(VelocityStore)
+	if !ok {
+		return "", 0, time.Time{}, errors.New("repository does not support velocity limits")
+	}
+	return store.ReserveEvent(key, window)
+}
+
+func (e *EncryptedCodeRepository) ReleaseEvent(key, event string) error {
+	store, ok := e.CodeRepositoryInterface.(VelocityStore)
+	if !ok {
+		return errors.New("repository does not support velocity limits")
+	}
+	return store.ReleaseEvent(key, event)
+}
+
+func (e *EncryptedCodeRepository) ReserveIdempotencyKey(key string, ttl time.Duration) (bool, error) {
+	store, ok := e.CodeRepositoryInterface.(IdempotencyStore)
+	if !ok {
+		return false, errors.New("repository does not support idempotency keys")
+	}
+	return store.ReserveIdempotencyKey(key, ttl)
+}
+
+func (e *EncryptedCodeRepository) ReleaseIdempotencyKey(key string) error {
+	store, ok := e.CodeRepositoryInterface.(IdempotencyStore)
+	if !ok {
+		return errors.New("repository does not support idempotency keys")
+	}
+	return store.ReleaseIdempotencyKey(key)
+}
+
+// SaveIdempotentResult seals the code like SaveCode before the wrapped repository keeps
+// it, it must implement IdempotencyStore.
+func (e *EncryptedCodeRepository) SaveIdempotentResult(key string, code *VerificationCode, ttl time.Duration) (*VerificationCode, error) {
+	store, ok := e.CodeRepositoryInterface.(IdempotencyStore)
+	if !ok {
+		return nil, errors.New("repository does not support idempotency keys")
+	}
+	sealed, err := e.sealCode(code)
+	if err != nil {
+		return nil, err
+	}
+	result, err := store.SaveIdempotentResult(key, sealed, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return e.open(result, result.Username, result.Scope)
+}
+
+func (e *EncryptedCodeRepository) GetIdempotentResult(key string) (*VerificationCode, error) {
+	store, ok := e.CodeRepositoryInterface.(IdempotencyStore)
+	if !ok {
+		return nil, errors.New("repository does not support idempotency keys")
+	}
+	result, err := store.GetIdempotentResult(key)
+	if err != nil {
+		return nil, err
+	}
+	return e.open(result, result.Username, result.Scope)
+}
+
+// sealCode returns a copy of code with its code and metadata sealed.
+func (e *EncryptedCodeRepository) sealCode(code *VerificationCode) (*VerificationCode, error) {
+	sealed := *code
+	var err error
+	if sealed.Code, err = e.seal(code.Username, code.Code, code.Scope); err != nil {
+		return nil, err
+	}
+	if sealed.Metadata, err = e.sealMetadata(code.Username, code.Scope, code.Metadata); err != nil {
+		return nil, err
+	}
+	return &sealed, nil
+}
+
+func (e *EncryptedCodeRepository) seal(username, code, scope string) (string, error) {
+	return e.sealValue([]byte(code), recordData(username, scope))
+}
+
+func (e *EncryptedCodeRepository) sealValue(value, data []byte) (string, error) {
+	sealed, err := e.keys.seal(value, data)
+	if err != nil {
+		return "", err
+	}
+	return encryptedCodePrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// sealMetadata seals metadata as JSON under encryptedMetadataKey, with other associated
+// data than the code so one can't be swapped for the other.
+func (e *EncryptedCodeRepository) sealMetadata(username, scope string, metadata map[string]string) (map[string]string, error) {
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := e.sealValue(data, metadataData(username, scope))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{encryptedMetadataKey: sealed}, nil
+}
+
+// open decrypts the code and the metadata, codes saved before encryption was enabled are
+// returned as is.
+func (e *EncryptedCodeRepository) open(verification *VerificationCode, username, scope string) (*VerificationCode, error) {
+	code, err := e.openValue(verification.Code, recordData(username, scope))
+	if err != nil {
+		return nil, err
+	}
+	verification.Code = code
+	if sealed, ok := verification.Metadata[encryptedMetadataKey]; ok && len(verification.Metadata) == 1 {
+		data, err := e.openValue(sealed, metadataData(username, scope))
+		if err != nil {
+			return nil, err
+		}
+		var metadata map[string]string
+		if err := json.Unmarshal([]byte(data), &metadata); err != nil {
+			return nil, ErrDecryptionFailed
+		}
+		verification.Metadata = metadata
+	}
+	return verification, nil
+}
+
+func (e *EncryptedCodeRepository) openValue(value string, data []byte) (string, error) {
+	encoded, ok := strings.CutPrefix(value, encryptedCodePrefix)
+	if !ok {
+		return value, nil
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrDecryptionFailed
+	}
+	opened, err := e.keys.open(sealed, data)
+	if err != nil {
+		return "", err
+	}
+	return string(opened), nil
+}
+
+func recordData(username, scope string) []byte {
+	return []byte(scope + "\x00" + username)
+}
+
+func metadataData(username, scope string) []byte {
+	return []byte(scope + "\x00" + username + "\x00metadata")
+}
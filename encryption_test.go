@@ -0,0 +1,201 @@
+package go_verification
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testEncryptionKeys(t *testing.T, current string) *EncryptionKeys {
+	keys, err := NewEncryptionKeys(current, map[string][]byte{
+		"2023": bytes.Repeat([]byte{1}, 32),
+		"2024": bytes.Repeat([]byte{2}, 16),
+	})
+	if err != nil {
+		t.Fatalf("NewEncryptionKeys error: %v", err)
+	}
+	return keys
+}
+
+func TestNewEncryptionKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string
+		keys    map[string][]byte
+	}{
+		{"missing current", "2025", map[string][]byte{"2024": make([]byte, 32)}},
+		{"invalid size", "2024", map[string][]byte{"2024": make([]byte, 20)}},
+		{"empty ID", "2024", map[string][]byte{"2024": make([]byte, 32), "": make([]byte, 32)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewEncryptionKeys(test.current, test.keys); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestEncryptingCodec(t *testing.T) {
+	old := NewEncryptingCodec(nil, testEncryptionKeys(t, "2023"))
+	binary, _ := NewEnvelopeCodec(BinaryFormat)
+	rotated := NewEncryptingCodec(binary, testEncryptionKeys(t, "2024"))
+
+	sealed, err := old.Marshal(testRecord())
+	if err != nil {
+		t.Fatalf("Marshal error: %v", err)
+	}
+	if bytes.Contains(sealed, []byte("user@example.com")) || bytes.Contains(sealed, []byte("123456")) {
+		t.Error("Expected the username and code to be encrypted")
+	}
+	decoded, err := rotated.Unmarshal(sealed)
+	if err != nil || decoded.Username != "user@example.com" || decoded.Code != "123456" {
+		t.Errorf("Expected records of the old key to be read after rotation, got %+v, %v", decoded, err)
+	}
+
+	plain, _ := JSONCodec{}.Marshal(testRecord())
+	if decoded, err := rotated.Unmarshal(plain); err != nil || decoded.Code != "123456" {
+		t.Errorf("Expected records written before encryption to be read, got %+v, %v", decoded, err)
+	}
+
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := rotated.Unmarshal(tampered); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed for a tampered record, got %v", err)
+	}
+	renamed := append([]byte{}, sealed...)
+	copy(renamed[2:], "2024")
+	if _, err := rotated.Unmarshal(renamed); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Expected the key ID to be authenticated, got %v", err)
+	}
+
+	unknown, _ := NewEncryptionKeys("2025", map[string][]byte{"2025": make([]byte, 32)})
+	if _, err := NewEncryptingCodec(nil, unknown).Unmarshal(sealed); !errors.Is(err, ErrUnknownEncryptionKey) {
+		t.Errorf("Expected ErrUnknownEncryptionKey, got %v", err)
+	}
+}
+
+func TestEncryptedCodeRepository(t *testing.T) {
+	inner := NewMemoryCodeRepository()
+	repo := NewEncryptedCodeRepository(inner, testEncryptionKeys(t, "2024"))
+
+	saved, err := repo.SaveCode("testuser", "123456", "login", time.Minute)
+	if err != nil || saved.Code != "123456" {
+		t.Fatalf("Expected the plain code from SaveCode, got %+v, %v", saved, err)
+	}
+	stored, _ := inner.GetCode("testuser", "login")
+	if !strings.HasPrefix(stored.Code, encryptedCodePrefix) || strings.Contains(stored.Code, "123456") {
+		t.Errorf("Expected an encrypted code in the wrapped repository, got %q", stored.Code)
+	}
+	code, err := repo.GetCode("testuser", "login")
+	if err != nil || code.Code != "123456" {
+		t.Errorf("Expected the decrypted code, got %+v, %v", code, err)
+	}
+
+	// a sealed code moved to another record doesn't open
+	_, _ = inner.SaveCode("otheruser", stored.Code, "login", time.Minute)
+	if _, err := repo.GetCode("otheruser", "login"); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed for a moved code, got %v", err)
+	}
+
+	page, err := repo.ListCodes(ListOptions{Username: "testuser", WithCodes: true})
+	if err != nil || len(page.Codes) != 1 || page.Codes[0].Code != "123456" {
+		t.Errorf("Expected the decrypted code in listings, got %+v, %v", page, err)
+	}
+
+	handler, _ := NewVerificationCodeHandler(&MockCodeGenerator{defCode: "654321"}, repo, &Config{})
+	tenant, err := handler.ForTenant("brand-a")
+	if err != nil {
+		t.Fatalf("ForTenant error: %v", err)
+	}
+	_, _ = tenant.GenerateCode("testuser", "login")
+	if valid, err := tenant.CheckCode("testuser", "654321", "login"); !valid {
+		t.Errorf("Expected the code of the tenant to be valid, got %v", err)
+	}
+}
+
+func TestEncryptedCodeRepository_SealedMetadata(t *testing.T) {
+	inner := NewMemoryCodeRepository()
+	repo := NewEncryptedCodeRepository(inner, testEncryptionKeys(t, "2024"))
+	metadata := map[string]string{"email": "new@example.com"}
+
+	saved, err := repo.SaveCodeWithMetadata("testuser", "123456", "change-email", time.Minute, metadata)
+	if err != nil || saved.Metadata["email"] != "new@example.com" {
+		t.Fatalf("Expected the plain metadata from SaveCodeWithMetadata, got %+v, %v", saved, err)
+	}
+	stored, _ := inner.GetCode("testuser", "change-email")
+	if len(stored.Metadata) != 1 || strings.Contains(stored.Metadata[encryptedMetadataKey], "example.com") {
+		t.Errorf("Expected sealed metadata in the wrapped repository, got %v", stored.Metadata)
+	}
+	code, err := repo.GetCode("testuser", "change-email")
+	if err != nil || code.Metadata["email"] != "new@example.com" {
+		t.Errorf("Expected the decrypted metadata, got %+v, %v", code, err)
+	}
+
+	// sealed metadata can't be passed off as the code
+	_, _ = inner.SaveCode("testuser", stored.Metadata[encryptedMetadataKey], "change-email", time.Minute)
+	if _, err := repo.GetCode("testuser", "change-email"); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed for swapped metadata, got %v", err)
+	}
+}
+
+func TestEncryptedCodeRepository_OptionalStores(t *testing.T) {
+	repo := NewEncryptedCodeRepository(NewMemoryCodeRepository(), testEncryptionKeys(t, "2024"))
+	handler, err := NewVerificationCodeHandler(&MockCodeGenerator{defCode: "654321"}, repo, &Config{
+		GrantIssuer: NewStoredGrantIssuer(repo),
+	})
+	if err != nil {
+		t.Fatalf("NewVerificationCodeHandler error: %v", err)
+	}
+
+	first, err := handler.GenerateCode("testuser", "login", WithIdempotencyKey("request-1"))
+	if err != nil {
+		t.Fatalf("GenerateCode error: %v", err)
+	}
+	second, err := handler.GenerateCode("testuser", "login", WithIdempotencyKey("request-1"))
+	if err != nil || second.Code != first.Code {
+		t.Errorf("Expected the first result again, got %+v, %v", second, err)
+	}
+
+	result, err := handler.VerifyCode("testuser", "654321", "login")
+	if err != nil || result.Grant == nil {
+		t.Fatalf("Expected a grant, got %+v, %v", result, err)
+	}
+
+	recovery, err := NewRecoveryCodes(mustGenerator(NewCharsetGenerator(CrockfordCharset, 12, "")), repo, RecoveryOptions{})
+	if err != nil {
+		t.Fatalf("NewRecoveryCodes error: %v", err)
+	}
+	if _, err := recovery.Generate("testuser"); err != nil {
+		t.Errorf("Generate error: %v", err)
+	}
+}
+
+func TestEncryptedCodeRepository_MissingStores(t *testing.T) {
+	repo := NewEncryptedCodeRepository(NewMockCodeRepository(), testEncryptionKeys(t, "2024"))
+	configs := map[string]*Config{
+		"valid codes":    {ValidCodes: 2},
+		"resend backoff": {ResendBackoff: ResendBackoff{Intervals: []time.Duration{time.Minute}}},
+	}
+	for name, config := range configs {
+		if _, err := NewVerificationCodeHandler(&MockCodeGenerator{defCode: "654321"}, repo, config); err == nil {
+			t.Errorf("Expected an error for %s without the store", name)
+		}
+	}
+	if _, err := NewVelocityGuard(repo, VelocityOptions{}); err == nil {
+		t.Error("Expected an error for velocity limits without the store")
+	}
+	if _, err := NewRecoveryCodes(mustGenerator(NewCharsetGenerator(CrockfordCharset, 12, "")), repo, RecoveryOptions{}); err == nil {
+		t.Error("Expected an error for recovery codes without the store")
+	}
+	if err := repo.DeletePreviousCodes("testuser", "login"); err == nil {
+		t.Error("Expected DeletePreviousCodes to fail without the store")
+	}
+
+	handler, _ := NewVerificationCodeHandler(&MockCodeGenerator{defCode: "654321"}, repo, &Config{})
+	if _, err := handler.GenerateCode("testuser", "login", WithIdempotencyKey("request-1")); err == nil {
+		t.Error("Expected an error for idempotency keys without the store")
+	}
+}
@@ -10,8 +10,12 @@ import (
 
 func main() {
 	ctx := context.Background()
+	generator, err := go_verification.NewAlphabetGenerator(6, false, false)
+	if err != nil {
+		log.Fatalf("Invalid generator : %s", err)
+	}
 	verification, _ := go_verification.NewVerificationCodeHandler(
-		go_verification.NewAlphabetGenerator(6, false, false), //Code Generator
+		generator, //Code Generator
 		go_verification.NewRedisCodeRepository(ctx, go_verification.RedisConfig{
 			Prefix: "verification",
 			Addr:   "localhost:6379",
@@ -10,8 +10,12 @@ import (
 
 func main() {
 	ctx := context.Background()
+	generator, err := go_verification.NewNumberGenerator(6, true)
+	if err != nil {
+		log.Fatalf("Invalid generator : %s", err)
+	}
 	verification, _ := go_verification.NewVerificationCodeHandler(
-		go_verification.NewNumberGenerator(6, true), //Code Generator
+		generator, //Code Generator
 		go_verification.NewRedisCodeRepository(ctx, go_verification.RedisConfig{
 			Prefix: "verification",
 			Addr:   "localhost:6379",
@@ -1,55 +1,95 @@
 package go_verification
 
 import (
+	crand "crypto/rand"
+	"errors"
 	"fmt"
-	"math/rand"
+	"math"
+	"math/big"
 	"regexp"
+	"regexp/syntax"
 	"strconv"
 	"strings"
-	"time"
 )
 
 type CodeGenerator interface {
 	Generate() string
 }
 
+// FallibleGenerator is implemented by generators that can fail, e.g. when a regex can't be
+// expanded or every code was blocked. Their Generate returns an empty string on failure
+// and GenerateE returns the reason. The handler prefers GenerateE when it is available.
+type FallibleGenerator interface {
+	GenerateE() (string, error)
+}
+
+// EntropyReporter is implemented by generators that know how strong their codes are.
+// Entropy returns the size of the generator's keyspace in bits, it is only what an
+// attacker faces when codes are drawn from crypto/rand.
+type EntropyReporter interface {
+	Entropy() float64
+}
+
 type NumberGenerator struct {
 	length         int
 	notZeroAtStart bool
 }
 
-func NewNumberGenerator(length int, notZeroAtStart bool) *NumberGenerator {
-	return &NumberGenerator{length: length, notZeroAtStart: notZeroAtStart}
+func NewNumberGenerator(length int, notZeroAtStart bool) (*NumberGenerator, error) {
+	if length <= 0 {
+		return nil, errors.New("length must be greater than 0")
+	}
+	return &NumberGenerator{length: length, notZeroAtStart: notZeroAtStart}, nil
 }
 
 func (n NumberGenerator) Generate() string {
-	rand.Seed(time.Now().UnixNano())
+	if n.length <= 0 {
+		return ""
+	}
 	chars := "0123456789"
 	result := make([]byte, n.length)
 	for i := 0; i < n.length; i++ {
-		result[i] = chars[rand.Intn(len(chars))]
+		result[i] = chars[randomIntn(len(chars))]
 	}
 
 	if n.notZeroAtStart && result[0] == '0' {
 		chars = "123456789"
-		result[0] = chars[rand.Intn(len(chars))]
+		result[0] = chars[randomIntn(len(chars))]
 	}
 
 	return string(result)
 }
 
+func (n NumberGenerator) Entropy() float64 {
+	if n.length <= 0 {
+		return 0
+	}
+	if n.notZeroAtStart {
+		return math.Log2(9) + float64(n.length-1)*math.Log2(10)
+	}
+	return float64(n.length) * math.Log2(10)
+}
+
 type AlphabetGenerator struct {
 	length        int
 	allCapital    bool
 	allNonCapital bool
 }
 
-func NewAlphabetGenerator(length int, allCapital bool, allNonCapital bool) *AlphabetGenerator {
-	return &AlphabetGenerator{length: length, allCapital: allCapital, allNonCapital: allNonCapital}
+func NewAlphabetGenerator(length int, allCapital bool, allNonCapital bool) (*AlphabetGenerator, error) {
+	if length <= 0 {
+		return nil, errors.New("length must be greater than 0")
+	}
+	if allCapital && allNonCapital {
+		return nil, errors.New("allCapital and allNonCapital can not be used together")
+	}
+	return &AlphabetGenerator{length: length, allCapital: allCapital, allNonCapital: allNonCapital}, nil
 }
 
 func (n AlphabetGenerator) Generate() string {
-	rand.Seed(time.Now().UnixNano())
+	if n.length <= 0 {
+		return ""
+	}
 	chars := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 	if n.allCapital {
 		chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
@@ -59,42 +99,80 @@ func (n AlphabetGenerator) Generate() string {
 
 	result := make([]byte, n.length)
 	for i := 0; i < n.length; i++ {
-		result[i] = chars[rand.Intn(len(chars))]
+		result[i] = chars[randomIntn(len(chars))]
 	}
 	return string(result)
 }
 
+func (n AlphabetGenerator) Entropy() float64 {
+	if n.allCapital || n.allNonCapital {
+		return float64(n.length) * math.Log2(26)
+	}
+	return float64(n.length) * math.Log2(52)
+}
+
 type WordGenerator struct {
 	length int
 }
 
-func NewWordGenerator(length int) *WordGenerator {
-	return &WordGenerator{length: length}
+func NewWordGenerator(length int) (*WordGenerator, error) {
+	if length <= 0 {
+		return nil, errors.New("length must be greater than 0")
+	}
+	return &WordGenerator{length: length}, nil
 }
 
 func (n WordGenerator) Generate() string {
-	rand.Seed(time.Now().UnixNano())
+	if n.length <= 0 {
+		return ""
+	}
 	chars := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
 	result := make([]byte, n.length)
 	for i := 0; i < n.length; i++ {
-		result[i] = chars[rand.Intn(len(chars))]
+		result[i] = chars[randomIntn(len(chars))]
 	}
 	return string(result)
 }
 
+func (n WordGenerator) Entropy() float64 {
+	return float64(n.length) * math.Log2(62)
+}
+
 type RegexGenerator struct {
 	regex string
 }
 
-func NewRegexGenerator(regex string) *RegexGenerator {
-	return &RegexGenerator{regex: regex}
+func NewRegexGenerator(regex string) (*RegexGenerator, error) {
+	if trimRegexDelimiters(regex) == "" {
+		return nil, errors.New("regex is empty")
+	}
+	if _, err := regexp.Compile(trimRegexDelimiters(regex)); err != nil {
+		return nil, fmt.Errorf("invalid regex: %w", err)
+	}
+	return &RegexGenerator{regex: regex}, nil
 }
 
 func (r *RegexGenerator) Generate() string {
-	regex := r.regex
-	regex = regexp.MustCompile(`^/?\^?`).ReplaceAllString(regex, "")
-	regex = regexp.MustCompile(`\$?/?$`).ReplaceAllString(regex, "")
+	code, _ := r.GenerateE()
+	return code
+}
+
+func (r *RegexGenerator) GenerateE() (code string, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			code, err = "", fmt.Errorf("can not generate a code from regex %q: %v", r.regex, recovered)
+		}
+	}()
+	code = r.generate()
+	if code == "" {
+		return "", fmt.Errorf("regex %q generated an empty code", r.regex)
+	}
+	return code, nil
+}
+
+func (r *RegexGenerator) generate() string {
+	regex := trimRegexDelimiters(r.regex)
 	regex = regexp.MustCompile(`{(\d+)}`).ReplaceAllString(regex, "{$1,$1}")
 	regex = r.replaceCustomMarks('?', "{0,1}", regex)
 	regex = r.replaceCustomMarks('*', "{0,"+strconv.Itoa(r.randomDigitNotNull())+"}", regex)
@@ -140,7 +218,7 @@ func (r *RegexGenerator) Generate() string {
 	regex = regexp.MustCompile(`\[([^\]]+)\]`).ReplaceAllStringFunc(regex, func(match string) string {
 		inner := match[1 : len(match)-1]
 		elements := strings.Split(inner, "")
-		randomIndex := rand.Intn(len(elements))
+		randomIndex := randomIntn(len(elements))
 		return elements[randomIndex]
 	})
 	regex = regexp.MustCompile(`\\w`).ReplaceAllStringFunc(regex, func(m string) string {
@@ -155,8 +233,49 @@ func (r *RegexGenerator) Generate() string {
 	return regex
 }
 
+// Entropy returns a lower bound of the regex keyspace. Optional parts, `*` and `.`
+// are counted as nothing and repeats as their minimum count.
+func (r *RegexGenerator) Entropy() float64 {
+	// Generate only produces lower case letters for \w
+	regex := strings.ReplaceAll(trimRegexDelimiters(r.regex), `\w`, `[a-z]`)
+	parsed, err := syntax.Parse(regex, syntax.Perl)
+	if err != nil {
+		return 0
+	}
+	return r.entropy(parsed)
+}
+
+func (r *RegexGenerator) entropy(re *syntax.Regexp) float64 {
+	switch re.Op {
+	case syntax.OpCharClass:
+		count := 0
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			count += int(re.Rune[i+1]-re.Rune[i]) + 1
+		}
+		return math.Log2(float64(count))
+	case syntax.OpConcat:
+		total := 0.0
+		for _, sub := range re.Sub {
+			total += r.entropy(sub)
+		}
+		return total
+	case syntax.OpAlternate:
+		least := math.Inf(1)
+		for _, sub := range re.Sub {
+			least = math.Min(least, r.entropy(sub))
+		}
+		return math.Log2(float64(len(re.Sub))) + least
+	case syntax.OpCapture, syntax.OpPlus:
+		return r.entropy(re.Sub[0])
+	case syntax.OpRepeat:
+		return float64(re.Min) * r.entropy(re.Sub[0])
+	default:
+		return 0
+	}
+}
+
 func (r *RegexGenerator) randomDigitNotNull() int {
-	return rand.Intn(9) + 1
+	return randomIntn(9) + 1
 }
 
 func (r *RegexGenerator) rangeSlice(start, end string) []int {
@@ -174,13 +293,11 @@ func (r *RegexGenerator) rangeIntSlice(start, end int) []int {
 }
 
 func (r *RegexGenerator) randomIntElement(array []int) int {
-	rand.Seed(time.Now().UnixNano())
-	return array[rand.Intn(len(array))]
+	return array[randomIntn(len(array))]
 }
 
 func (r *RegexGenerator) randomElement(array []string) string {
-	rand.Seed(time.Now().UnixNano())
-	return array[rand.Intn(len(array))]
+	return array[randomIntn(len(array))]
 }
 
 func (r *RegexGenerator) repeatString(s string, times int) string {
@@ -203,7 +320,7 @@ func (r *RegexGenerator) parseInt(s string) int {
 }
 
 func (r *RegexGenerator) randomLetter() string {
-	return string(rune(rand.Intn(122-97+1) + 97))
+	return string(rune(randomIntn(122-97+1) + 97))
 }
 
 func (r *RegexGenerator) asciify(s string) string {
@@ -211,11 +328,11 @@ func (r *RegexGenerator) asciify(s string) string {
 }
 
 func (r *RegexGenerator) randomAscii() string {
-	return fmt.Sprint(rune(rand.Intn(126-33+1) + 33))
+	return fmt.Sprint(rune(randomIntn(126-33+1) + 33))
 }
 
 func (r *RegexGenerator) randomDigit() int {
-	return rand.Intn(10)
+	return randomIntn(10)
 }
 
 func (r *RegexGenerator) replaceCustomMarks(pattern rune, replaceWith, input string) string {
@@ -245,3 +362,26 @@ func (r *RegexGenerator) expandRange(start, end byte) string {
 	}
 	return string(expanded)
 }
+
+func trimRegexDelimiters(regex string) string {
+	regex = regexp.MustCompile(`^/?\^?`).ReplaceAllString(regex, "")
+	return regexp.MustCompile(`\$?/?$`).ReplaceAllString(regex, "")
+}
+
+// randomIntn is secureIntn for generators that can't return errors, crypto/rand only
+// fails when the system has no randomness left.
+func randomIntn(n int) int {
+	v, err := secureIntn(n)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func secureIntn(n int) (int, error) {
+	v, err := crand.Int(crand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(v.Int64()), nil
+}
@@ -0,0 +1,287 @@
+package go_verification
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// GeneratorFactory builds a generator from the arguments of a spec, everything after
+// the first colon. Use SplitSpecArgs to read comma separated arguments.
+type GeneratorFactory func(args string) (CodeGenerator, error)
+
+// GeneratorRegistry turns spec strings like "number:6,nozero" into generators.
+type GeneratorRegistry struct {
+	mu        sync.RWMutex
+	factories map[string]GeneratorFactory
+}
+
+var charsetPresets = map[string]string{
+	"crockford":    CrockfordCharset,
+	"no-ambiguous": NoAmbiguousCharset,
+	"hex":          HexCharset,
+	"upper-digits": UpperDigitsCharset,
+}
+
+var defaultGeneratorRegistry = NewGeneratorRegistry()
+
+// NewGeneratorRegistry creates a registry with the built-in generators:
+//
+//	number:LENGTH[,nozero]
+//	alpha:LENGTH[,upper|lower]
+//	word:LENGTH
+//	regex:PATTERN
+//	charset:PRESET|CHARS[:LENGTH][,mask=MASK]
+//	passphrase:COUNT[,sep=SEPARATOR][,case=lower|title|upper]
+//	blocklist:RETRIES:SPEC
+//
+// Charset presets are crockford, no-ambiguous, hex and upper-digits.
+func NewGeneratorRegistry() *GeneratorRegistry {
+	r := &GeneratorRegistry{factories: make(map[string]GeneratorFactory)}
+	r.factories["number"] = numberGeneratorFactory
+	r.factories["alpha"] = alphabetGeneratorFactory
+	r.factories["word"] = wordGeneratorFactory
+	r.factories["regex"] = regexGeneratorFactory
+	r.factories["charset"] = charsetGeneratorFactory
+	r.factories["passphrase"] = passphraseGeneratorFactory
+	r.factories["blocklist"] = r.blocklistGeneratorFactory
+	return r
+}
+
+// Register adds a generator type. It fails when the name is already registered.
+func (r *GeneratorRegistry) Register(name string, factory GeneratorFactory) error {
+	if name == "" || strings.ContainsAny(name, ":, ") {
+		return fmt.Errorf("invalid generator name %q", name)
+	}
+	if factory == nil {
+		return errors.New("generator factory is nil")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.factories[name]; ok {
+		return fmt.Errorf("generator %q is already registered", name)
+	}
+	r.factories[name] = factory
+	return nil
+}
+
+// Parse builds the generator described by spec.
+func (r *GeneratorRegistry) Parse(spec string) (CodeGenerator, error) {
+	name, args, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	r.mu.RLock()
+	factory, ok := r.factories[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("invalid generator spec %q: unknown generator %q, available are %s", spec, name, strings.Join(r.names(), ", "))
+	}
+
+	generator, err := factory(args)
+	if err != nil {
+		return nil, fmt.Errorf("invalid generator spec %q: %w", spec, err)
+	}
+	return generator, nil
+}
+
+func (r *GeneratorRegistry) names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RegisterGenerator adds a generator type to the default registry.
+func RegisterGenerator(name string, factory GeneratorFactory) error {
+	return defaultGeneratorRegistry.Register(name, factory)
+}
+
+// ParseGenerator builds a generator from spec with the default registry.
+func ParseGenerator(spec string) (CodeGenerator, error) {
+	return defaultGeneratorRegistry.Parse(spec)
+}
+
+// SplitSpecArgs splits comma separated spec arguments. Arguments with an equal sign
+// are returned as options, others as positional arguments in order.
+func SplitSpecArgs(args string) (positional []string, options map[string]string) {
+	options = make(map[string]string)
+	if strings.TrimSpace(args) == "" {
+		return nil, options
+	}
+	for _, arg := range strings.Split(args, ",") {
+		arg = strings.TrimSpace(arg)
+		if key, value, ok := strings.Cut(arg, "="); ok {
+			options[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		} else {
+			positional = append(positional, arg)
+		}
+	}
+	return positional, options
+}
+
+func specLength(positional []string, index int) (int, error) {
+	if len(positional) <= index {
+		return 0, errors.New("length is missing")
+	}
+	length, err := strconv.Atoi(positional[index])
+	if err != nil {
+		return 0, fmt.Errorf("invalid length %q", positional[index])
+	}
+	return length, nil
+}
+
+func checkSpecArgs(positional []string, max int, options map[string]string, allowed ...string) error {
+	if len(positional) > max {
+		return fmt.Errorf("unknown argument %q", positional[max])
+	}
+	for key := range options {
+		found := false
+		for _, name := range allowed {
+			found = found || key == name
+		}
+		if !found {
+			return fmt.Errorf("unknown option %q", key)
+		}
+	}
+	return nil
+}
+
+func numberGeneratorFactory(args string) (CodeGenerator, error) {
+	positional, options := SplitSpecArgs(args)
+	length, err := specLength(positional, 0)
+	if err != nil {
+		return nil, err
+	}
+	notZeroAtStart := false
+	if len(positional) > 1 {
+		if positional[1] != "nozero" {
+			return nil, fmt.Errorf("unknown argument %q, expected nozero", positional[1])
+		}
+		notZeroAtStart = true
+	}
+	if err := checkSpecArgs(positional, 2, options); err != nil {
+		return nil, err
+	}
+	return asGenerator(NewNumberGenerator(length, notZeroAtStart))
+}
+
+func alphabetGeneratorFactory(args string) (CodeGenerator, error) {
+	positional, options := SplitSpecArgs(args)
+	length, err := specLength(positional, 0)
+	if err != nil {
+		return nil, err
+	}
+	allCapital, allNonCapital := false, false
+	if len(positional) > 1 {
+		switch positional[1] {
+		case "upper":
+			allCapital = true
+		case "lower":
+			allNonCapital = true
+		default:
+			return nil, fmt.Errorf("unknown argument %q, expected upper or lower", positional[1])
+		}
+	}
+	if err := checkSpecArgs(positional, 2, options); err != nil {
+		return nil, err
+	}
+	return asGenerator(NewAlphabetGenerator(length, allCapital, allNonCapital))
+}
+
+func wordGeneratorFactory(args string) (CodeGenerator, error) {
+	positional, options := SplitSpecArgs(args)
+	length, err := specLength(positional, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSpecArgs(positional, 1, options); err != nil {
+		return nil, err
+	}
+	return asGenerator(NewWordGenerator(length))
+}
+
+func regexGeneratorFactory(args string) (CodeGenerator, error) {
+	return asGenerator(NewRegexGenerator(args))
+}
+
+func charsetGeneratorFactory(args string) (CodeGenerator, error) {
+	charset, rest := args, ""
+	if i := strings.IndexAny(args, ":,"); i >= 0 {
+		charset, rest = args[:i], args[i+1:]
+	}
+	if preset, ok := charsetPresets[charset]; ok {
+		charset = preset
+	}
+	if charset == "" {
+		return nil, errors.New("charset is missing")
+	}
+
+	positional, options := SplitSpecArgs(rest)
+	length := 0
+	if len(positional) > 0 {
+		var err error
+		if length, err = specLength(positional, 0); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkSpecArgs(positional, 1, options, "mask"); err != nil {
+		return nil, err
+	}
+	return asGenerator(NewCharsetGenerator(charset, length, options["mask"]))
+}
+
+func passphraseGeneratorFactory(args string) (CodeGenerator, error) {
+	positional, options := SplitSpecArgs(args)
+	count, err := specLength(positional, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSpecArgs(positional, 1, options, "sep", "case"); err != nil {
+		return nil, err
+	}
+
+	separator := "-"
+	if sep, ok := options["sep"]; ok {
+		separator = sep
+	}
+	capitalization := LowerCase
+	switch options["case"] {
+	case "", "lower":
+	case "title":
+		capitalization = TitleCase
+	case "upper":
+		capitalization = UpperCase
+	default:
+		return nil, fmt.Errorf("unknown case %q, expected lower, title or upper", options["case"])
+	}
+	return asGenerator(NewPassphraseGenerator(nil, count, separator, capitalization))
+}
+
+func (r *GeneratorRegistry) blocklistGeneratorFactory(args string) (CodeGenerator, error) {
+	retries, spec, ok := strings.Cut(args, ":")
+	if !ok {
+		return nil, errors.New("expected blocklist:RETRIES:SPEC")
+	}
+	maxRetries, err := strconv.Atoi(retries)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retries %q", retries)
+	}
+	generator, err := r.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	return asGenerator(NewBlocklistGenerator(generator, DefaultBlocklist(), maxRetries))
+}
+
+func asGenerator[T CodeGenerator](generator T, err error) (CodeGenerator, error) {
+	if err != nil {
+		return nil, err
+	}
+	return generator, nil
+}
@@ -0,0 +1,111 @@
+package go_verification
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseGenerator(t *testing.T) {
+	tests := []struct {
+		spec    string
+		pattern string
+	}{
+		{"number:6", `^\d{6}$`},
+		{"number:6,nozero", `^[1-9]\d{5}$`},
+		{"alpha:8,upper", `^[A-Z]{8}$`},
+		{"alpha:8,lower", `^[a-z]{8}$`},
+		{"word:5", `^[a-zA-Z0-9]{5}$`},
+		{`regex:N-\d{5}`, `^N-\d{5}$`},
+		{`regex:^[ab]{2},\d:x$`, `^[ab]{2},\d:x$`},
+		{"charset:crockford:10,mask=XXXXX-XXXXX", `^[0-9A-HJKMNP-TV-Z]{5}-[0-9A-HJKMNP-TV-Z]{5}$`},
+		{"charset:hex,mask=XXXX-XXXX", `^[0-9a-f]{4}-[0-9a-f]{4}$`},
+		{"charset:AB:3", `^[AB]{3}$`},
+		{"passphrase:3", `^[a-z]+-[a-z]+-[a-z]+$`},
+		{"passphrase:2,sep=.,case=upper", `^[A-Z]+\.[A-Z]+$`},
+		{"blocklist:5:number:6", `^\d{6}$`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			generator, err := ParseGenerator(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseGenerator error: %v", err)
+			}
+			if code := generator.Generate(); !regexp.MustCompile(tt.pattern).MatchString(code) {
+				t.Errorf("Expected code matching %s, but got %s", tt.pattern, code)
+			}
+		})
+	}
+}
+
+func TestParseGeneratorErrors(t *testing.T) {
+	tests := []struct {
+		spec    string
+		message string
+	}{
+		{"", "unknown generator"},
+		{"digits:6", "unknown generator"},
+		{"number", "length is missing"},
+		{"number:six", "invalid length"},
+		{"number:0", "length must be greater than 0"},
+		{"number:6,zero", "expected nozero"},
+		{"number:6,nozero,extra", "unknown argument"},
+		{"alpha:6,capital", "expected upper or lower"},
+		{"word:6,mask=XX", "unknown option"},
+		{"regex:[a-", "invalid regex"},
+		{"charset:crockford:10,mask=XXXX", "placeholders"},
+		{"passphrase:3,case=camel", "unknown case"},
+		{"blocklist:number:6", "invalid retries"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			_, err := ParseGenerator(tt.spec)
+			if err == nil {
+				t.Fatal("Expected an error, but got nil")
+			}
+			if !strings.Contains(err.Error(), tt.message) || !strings.Contains(err.Error(), tt.spec) {
+				t.Errorf("Expected error about %q for spec %q, but got %v", tt.message, tt.spec, err)
+			}
+		})
+	}
+}
+
+func TestGeneratorRegistryRegister(t *testing.T) {
+	registry := NewGeneratorRegistry()
+	err := registry.Register("fixed", func(args string) (CodeGenerator, error) {
+		return &MockCodeGenerator{defCode: args}, nil
+	})
+	if err != nil {
+		t.Fatalf("Register error: %v", err)
+	}
+
+	generator, err := registry.Parse("fixed:abc")
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if code := generator.Generate(); code != "abc" {
+		t.Errorf("Expected abc, but got %s", code)
+	}
+
+	if err := registry.Register("number", numberGeneratorFactory); err == nil {
+		t.Error("Expected an error when registering an existing name")
+	}
+	if err := registry.Register("bad:name", numberGeneratorFactory); err == nil {
+		t.Error("Expected an error for invalid name")
+	}
+	if _, err := ParseGenerator("fixed:abc"); err == nil {
+		t.Error("Expected the default registry to be unaffected")
+	}
+}
+
+func TestSplitSpecArgs(t *testing.T) {
+	positional, options := SplitSpecArgs(" 6 , nozero, mask=XX-XX ")
+	if len(positional) != 2 || positional[0] != "6" || positional[1] != "nozero" {
+		t.Errorf("Unexpected positional arguments %v", positional)
+	}
+	if options["mask"] != "XX-XX" {
+		t.Errorf("Unexpected options %v", options)
+	}
+}
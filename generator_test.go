@@ -1,13 +1,17 @@
 package go_verification
 
 import (
+	"math"
 	"regexp"
 	"testing"
 )
 
 func TestNumberGeneratorGenerate(t *testing.T) {
 	t.Run("Test default configuration", func(t *testing.T) {
-		gen := NewNumberGenerator(5, false)
+		gen, err := NewNumberGenerator(5, false)
+		if err != nil {
+			t.Fatalf("NewNumberGenerator error: %v", err)
+		}
 		result := gen.Generate()
 
 		if len(result) != 5 {
@@ -22,7 +26,10 @@ func TestNumberGeneratorGenerate(t *testing.T) {
 	})
 
 	t.Run("Test non-zero start", func(t *testing.T) {
-		gen := NewNumberGenerator(5, true)
+		gen, err := NewNumberGenerator(5, true)
+		if err != nil {
+			t.Fatalf("NewNumberGenerator error: %v", err)
+		}
 		result := gen.Generate()
 
 		if len(result) != 5 {
@@ -56,7 +63,10 @@ func TestAlphabetGeneratorGenerate(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			generator := NewAlphabetGenerator(tt.length, tt.allCapital, tt.allNonCapital)
+			generator, err := NewAlphabetGenerator(tt.length, tt.allCapital, tt.allNonCapital)
+			if err != nil {
+				t.Fatalf("NewAlphabetGenerator error: %v", err)
+			}
 			result := generator.Generate()
 
 			if len(result) != tt.expectedLength {
@@ -86,7 +96,10 @@ func isNonCapital(c byte) bool {
 
 func TestWordGeneratorGenerate(t *testing.T) {
 	t.Run("Test default configuration", func(t *testing.T) {
-		gen := NewWordGenerator(5)
+		gen, err := NewWordGenerator(5)
+		if err != nil {
+			t.Fatalf("NewWordGenerator error: %v", err)
+		}
 		result := gen.Generate()
 
 		if len(result) != 5 {
@@ -98,7 +111,10 @@ func TestWordGeneratorGenerate(t *testing.T) {
 func TestRegexGeneratorGenerate(t *testing.T) {
 	t.Run("Test default configuration", func(t *testing.T) {
 		expectedPattern := `^G-\d{1,2}\d+\w+\d{1}(this|that)?[12]{2}$`
-		gen := NewRegexGenerator(expectedPattern)
+		gen, err := NewRegexGenerator(expectedPattern)
+		if err != nil {
+			t.Fatalf("NewRegexGenerator error: %v", err)
+		}
 		result := gen.Generate()
 
 		matched, err := regexp.MatchString(expectedPattern, result)
@@ -111,3 +127,85 @@ func TestRegexGeneratorGenerate(t *testing.T) {
 		}
 	})
 }
+
+func TestGeneratorEntropy(t *testing.T) {
+	charset, _ := NewCharsetGenerator(HexCharset, 8, "")
+	tests := []struct {
+		name      string
+		generator EntropyReporter
+		expected  float64
+	}{
+		{"Test numbers", mustGenerator(NewNumberGenerator(6, false)), 6 * math.Log2(10)},
+		{"Test numbers without zero at start", mustGenerator(NewNumberGenerator(2, true)), math.Log2(90)},
+		{"Test capital alphabets", mustGenerator(NewAlphabetGenerator(4, true, false)), 4 * math.Log2(26)},
+		{"Test mixed alphabets", mustGenerator(NewAlphabetGenerator(4, false, false)), 4 * math.Log2(52)},
+		{"Test words", mustGenerator(NewWordGenerator(3)), 3 * math.Log2(62)},
+		{"Test charset", charset, 32},
+		{"Test regex literal", mustGenerator(NewRegexGenerator(`a`)), 0},
+		{"Test regex digits", mustGenerator(NewRegexGenerator(`^N-\d{5}$`)), 5 * math.Log2(10)},
+		{"Test regex classes and alternation", mustGenerator(NewRegexGenerator(`[12]{2}(this|that)\w`)), 2 + 1 + math.Log2(26)},
+		{"Test regex optional parts", mustGenerator(NewRegexGenerator(`\d?\d*\d+`)), math.Log2(10)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.generator.Entropy(); math.Abs(result-tt.expected) > 1e-9 {
+				t.Errorf("Expected entropy %f, but got %f", tt.expected, result)
+			}
+		})
+	}
+}
+
+func mustGenerator[T CodeGenerator](generator T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return generator
+}
+
+func TestGeneratorConstructorsValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"Test zero length numbers", second(NewNumberGenerator(0, true))},
+		{"Test negative length numbers", second(NewNumberGenerator(-1, false))},
+		{"Test zero length alphabets", second(NewAlphabetGenerator(0, false, false))},
+		{"Test conflicting alphabet cases", second(NewAlphabetGenerator(6, true, true))},
+		{"Test negative length words", second(NewWordGenerator(-3))},
+		{"Test empty regex", second(NewRegexGenerator(`^$`))},
+		{"Test invalid regex", second(NewRegexGenerator(`[a-`))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.err == nil {
+				t.Error("Expected an error, but got nil")
+			}
+		})
+	}
+}
+
+func second[T any](_ T, err error) error {
+	return err
+}
+
+func TestGeneratorsNeverPanic(t *testing.T) {
+	generators := []CodeGenerator{
+		NumberGenerator{},
+		AlphabetGenerator{length: -1},
+		WordGenerator{length: -1},
+		&RegexGenerator{},
+		&RegexGenerator{regex: `a{3,1}`},
+	}
+
+	for _, generator := range generators {
+		if code := generator.Generate(); code != "" {
+			t.Errorf("Expected empty code from invalid generator, but got %s", code)
+		}
+	}
+
+	if _, err := (&RegexGenerator{regex: `a{3,1}`}).GenerateE(); err == nil {
+		t.Error("Expected GenerateE to return an error for invalid regex")
+	}
+}
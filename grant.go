@@ -0,0 +1,201 @@
+package go_verification
+
+import (
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidGrant   = errors.New("invalid grant")
+	ErrGrantsDisabled = errors.New("grants are not enabled")
+)
+
+// Grant proves that Username passed the verification of Scope. It is issued by
+// VerifyCode and can be redeemed once with RedeemGrant.
+type Grant struct {
+	Token     string `json:"-"`
+	Username  string
+	Scope     string
+	ExpiredAt time.Time
+}
+
+// GrantIssuer mints and redeems grant tokens.
+type GrantIssuer interface {
+	Issue(username, scope string, ttl time.Duration) (*Grant, error)
+	// Redeem returns ErrInvalidGrant for unknown, expired, used or other scope tokens.
+	Redeem(token, scope string) (*Grant, error)
+	// Peek checks a token like Redeem without consuming it.
+	Peek(token, scope string) (*Grant, error)
+}
+
+// GrantStore keeps issued grants until they are taken. RedisCodeRepository and
+// MemoryCodeRepository implement it, EncryptedCodeRepository forwards it.
+type GrantStore interface {
+	SaveGrant(id string, grant *Grant, ttl time.Duration) error
+	// GetGrant returns a grant without deleting it, ErrInvalidGrant when it doesn't exist.
+	GetGrant(id string) (*Grant, error)
+	// TakeGrant returns and deletes a grant at once, ErrInvalidGrant when it doesn't exist.
+	TakeGrant(id string) (*Grant, error)
+}
+
+type StoredGrantIssuer struct {
+	store GrantStore
+}
+
+// NewStoredGrantIssuer issues random opaque tokens and keeps them in store.
+func NewStoredGrantIssuer(store GrantStore) *StoredGrantIssuer {
+	return &StoredGrantIssuer{store: store}
+}
+
+func (s *StoredGrantIssuer) Issue(username, scope string, ttl time.Duration) (*Grant, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	grant := &Grant{Token: token, Username: username, Scope: scope, ExpiredAt: time.Now().Add(ttl)}
+	if err := s.store.SaveGrant(token, grant, ttl); err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
+
+// Redeem checks the grant before taking it, so redeeming it for another scope doesn't
+// burn it.
+func (s *StoredGrantIssuer) Redeem(token, scope string) (*Grant, error) {
+	if _, err := s.Peek(token, scope); err != nil {
+		return nil, err
+	}
+	return s.check(s.store.TakeGrant, token, scope)
+}
+
+func (s *StoredGrantIssuer) Peek(token, scope string) (*Grant, error) {
+	return s.check(s.store.GetGrant, token, scope)
+}
+
+func (s *StoredGrantIssuer) check(load func(id string) (*Grant, error), token, scope string) (*Grant, error) {
+	grant, err := load(token)
+	if err != nil {
+		return nil, err
+	}
+	if grant.Scope != scope || !grant.ExpiredAt.After(time.Now()) {
+		return nil, ErrInvalidGrant
+	}
+	grant.Token = token
+	return grant, nil
+}
+
+type HMACGrantIssuer struct {
+	secret []byte
+	store  GrantStore
+}
+
+type hmacGrantPayload struct {
+	ID        string `json:"id"`
+	Username  string `json:"u"`
+	Scope     string `json:"s"`
+	ExpiredAt int64  `json:"exp"`
+}
+
+// NewHMACGrantIssuer issues signed tokens carrying the grant itself. With a store the
+// grant ID is saved so every token can be redeemed once, with a nil store tokens are
+// stateless and can be redeemed until they expire.
+func NewHMACGrantIssuer(secret []byte, store GrantStore) (*HMACGrantIssuer, error) {
+	if len(secret) < 32 {
+		return nil, errors.New("grant secret must be at least 32 bytes")
+	}
+	return &HMACGrantIssuer{secret: secret, store: store}, nil
+}
+
+func (h *HMACGrantIssuer) Issue(username, scope string, ttl time.Duration) (*Grant, error) {
+	id, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	grant := &Grant{Username: username, Scope: scope, ExpiredAt: time.Now().Add(ttl)}
+	payload, err := json.Marshal(hmacGrantPayload{ID: id, Username: username, Scope: scope, ExpiredAt: grant.ExpiredAt.Unix()})
+	if err != nil {
+		return nil, err
+	}
+	if h.store != nil {
+		if err := h.store.SaveGrant(id, grant, ttl); err != nil {
+			return nil, err
+		}
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	grant.Token = encoded + "." + base64.RawURLEncoding.EncodeToString(h.sign(encoded))
+	return grant, nil
+}
+
+func (h *HMACGrantIssuer) Redeem(token, scope string) (*Grant, error) {
+	var load func(id string) (*Grant, error)
+	if h.store != nil {
+		load = h.store.TakeGrant
+	}
+	return h.check(load, token, scope)
+}
+
+func (h *HMACGrantIssuer) Peek(token, scope string) (*Grant, error) {
+	var load func(id string) (*Grant, error)
+	if h.store != nil {
+		load = h.store.GetGrant
+	}
+	return h.check(load, token, scope)
+}
+
+func (h *HMACGrantIssuer) check(load func(id string) (*Grant, error), token, scope string) (*Grant, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidGrant
+	}
+	decodedSignature, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(decodedSignature, h.sign(encoded)) {
+		return nil, ErrInvalidGrant
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidGrant
+	}
+	var payload hmacGrantPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, ErrInvalidGrant
+	}
+
+	grant := &Grant{Token: token, Username: payload.Username, Scope: payload.Scope, ExpiredAt: time.Unix(payload.ExpiredAt, 0)}
+	if grant.Scope != scope || !grant.ExpiredAt.After(time.Now()) {
+		return nil, ErrInvalidGrant
+	}
+	if load != nil {
+		if _, err := load(payload.ID); err != nil {
+			return nil, err
+		}
+	}
+	return grant, nil
+}
+
+func (h *HMACGrantIssuer) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func randomToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := crand.Read(token); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// grantKey keeps tokens out of storage key names.
+func grantKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
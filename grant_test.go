@@ -0,0 +1,115 @@
+package go_verification
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoredGrantIssuer(t *testing.T) {
+	issuer := NewStoredGrantIssuer(NewMemoryCodeRepository())
+
+	grant, err := issuer.Issue("testuser", "forget-password", time.Minute)
+	if err != nil {
+		t.Fatalf("Issue error: %v", err)
+	}
+	if grant.Token == "" || grant.Username != "testuser" {
+		t.Fatalf("Unexpected grant %+v", grant)
+	}
+
+	if _, err := issuer.Redeem("unknown", "forget-password"); !errors.Is(err, ErrInvalidGrant) {
+		t.Errorf("Expected ErrInvalidGrant for unknown token, got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		peeked, err := issuer.Peek(grant.Token, "forget-password")
+		if err != nil {
+			t.Fatalf("Peek error: %v", err)
+		}
+		if peeked.Username != "testuser" {
+			t.Errorf("Unexpected peeked grant %+v", peeked)
+		}
+	}
+
+	redeemed, err := issuer.Redeem(grant.Token, "forget-password")
+	if err != nil {
+		t.Fatalf("Redeem error: %v", err)
+	}
+	if redeemed.Username != "testuser" || redeemed.Scope != "forget-password" {
+		t.Errorf("Unexpected redeemed grant %+v", redeemed)
+	}
+
+	if _, err := issuer.Redeem(grant.Token, "forget-password"); !errors.Is(err, ErrInvalidGrant) {
+		t.Errorf("Expected the grant to be single-use, got %v", err)
+	}
+}
+
+func TestStoredGrantIssuer_OtherScope(t *testing.T) {
+	issuer := NewStoredGrantIssuer(NewMemoryCodeRepository())
+	grant, _ := issuer.Issue("testuser", "forget-password", time.Minute)
+
+	if _, err := issuer.Redeem(grant.Token, "delete-account"); !errors.Is(err, ErrInvalidGrant) {
+		t.Errorf("Expected ErrInvalidGrant for other scope, got %v", err)
+	}
+	if _, err := issuer.Redeem(grant.Token, "forget-password"); err != nil {
+		t.Errorf("Expected the grant to survive a redeem for another scope, but got %v", err)
+	}
+}
+
+func TestHMACGrantIssuer(t *testing.T) {
+	secret := []byte(strings.Repeat("s", 32))
+	if _, err := NewHMACGrantIssuer([]byte("short"), nil); err == nil {
+		t.Error("Expected an error for a short secret")
+	}
+
+	t.Run("Test stateless", func(t *testing.T) {
+		issuer, _ := NewHMACGrantIssuer(secret, nil)
+		grant, err := issuer.Issue("testuser", "forget-password", time.Minute)
+		if err != nil {
+			t.Fatalf("Issue error: %v", err)
+		}
+
+		redeemed, err := issuer.Redeem(grant.Token, "forget-password")
+		if err != nil {
+			t.Fatalf("Redeem error: %v", err)
+		}
+		if redeemed.Username != "testuser" {
+			t.Errorf("Expected testuser, got %s", redeemed.Username)
+		}
+		if _, err := issuer.Redeem(grant.Token, "login"); !errors.Is(err, ErrInvalidGrant) {
+			t.Errorf("Expected ErrInvalidGrant for other scope, got %v", err)
+		}
+	})
+
+	t.Run("Test single-use with store", func(t *testing.T) {
+		issuer, _ := NewHMACGrantIssuer(secret, NewMemoryCodeRepository())
+		grant, _ := issuer.Issue("testuser", "forget-password", time.Minute)
+
+		if _, err := issuer.Redeem(grant.Token, "forget-password"); err != nil {
+			t.Fatalf("Redeem error: %v", err)
+		}
+		if _, err := issuer.Redeem(grant.Token, "forget-password"); !errors.Is(err, ErrInvalidGrant) {
+			t.Errorf("Expected the grant to be single-use, got %v", err)
+		}
+	})
+
+	t.Run("Test tampered and expired tokens", func(t *testing.T) {
+		issuer, _ := NewHMACGrantIssuer(secret, nil)
+		other, _ := NewHMACGrantIssuer([]byte(strings.Repeat("o", 32)), nil)
+
+		grant, _ := other.Issue("testuser", "forget-password", time.Minute)
+		if _, err := issuer.Redeem(grant.Token, "forget-password"); !errors.Is(err, ErrInvalidGrant) {
+			t.Errorf("Expected ErrInvalidGrant for other secret, got %v", err)
+		}
+
+		expired, _ := issuer.Issue("testuser", "forget-password", -time.Minute)
+		if _, err := issuer.Redeem(expired.Token, "forget-password"); !errors.Is(err, ErrInvalidGrant) {
+			t.Errorf("Expected ErrInvalidGrant for expired token, got %v", err)
+		}
+
+		if _, err := issuer.Redeem("not-a-token", "forget-password"); !errors.Is(err, ErrInvalidGrant) {
+			t.Errorf("Expected ErrInvalidGrant for garbage, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,160 @@
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	go_verification "github.com/milito-78/go-verification"
+	"github.com/milito-78/go-verification/grpcapi/verificationpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RateLimitError is returned by Client when the server answers RESOURCE_EXHAUSTED.
+type RateLimitError struct {
+	Message string
+	After   time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return e.Message
+}
+
+func (e *RateLimitError) RetryAfter() time.Duration {
+	return e.After
+}
+
+// Client calls a VerificationService and translates its status codes back to the
+// package errors, so callers can keep using errors.Is with ErrCodeNotFound and friends.
+type Client struct {
+	client verificationpb.VerificationServiceClient
+}
+
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return &Client{client: verificationpb.NewVerificationServiceClient(conn)}
+}
+
+func (c *Client) Generate(ctx context.Context, username, scope string) (*go_verification.VerificationCode, error) {
+	var trailer metadata.MD
+	response, err := c.client.Generate(ctx, &verificationpb.GenerateRequest{Username: username, Scope: scope}, grpc.Trailer(&trailer))
+	if err != nil {
+		return nil, fromStatus(err, trailer)
+	}
+	return toVerificationCode(response), nil
+}
+
+// Verify returns go_verification.ErrInvalidCode for wrong codes.
+func (c *Client) Verify(ctx context.Context, username, code, scope string) (*go_verification.Verification, error) {
+	var trailer metadata.MD
+	response, err := c.client.Verify(ctx, &verificationpb.VerifyRequest{Username: username, Scope: scope, Code: code}, grpc.Trailer(&trailer))
+	if err != nil {
+		return nil, fromStatus(err, trailer)
+	}
+	verification := &go_verification.Verification{}
+	if response.GetGrant() != "" {
+		verification.Grant = &go_verification.Grant{
+			Token:     response.GetGrant(),
+			Username:  username,
+			Scope:     scope,
+			ExpiredAt: response.GetGrantExpiresAt().AsTime(),
+		}
+	}
+	return verification, nil
+}
+
+func (c *Client) Resend(ctx context.Context, username, scope string, resetExpireTime bool) (*go_verification.VerificationCode, error) {
+	var trailer metadata.MD
+	response, err := c.client.Resend(ctx, &verificationpb.ResendRequest{Username: username, Scope: scope, ResetExpireTime: resetExpireTime}, grpc.Trailer(&trailer))
+	if err != nil {
+		return nil, fromStatus(err, trailer)
+	}
+	return toVerificationCode(response), nil
+}
+
+func (c *Client) Revoke(ctx context.Context, username, scope string) error {
+	var trailer metadata.MD
+	_, err := c.client.Revoke(ctx, &verificationpb.RevokeRequest{Username: username, Scope: scope}, grpc.Trailer(&trailer))
+	return fromStatus(err, trailer)
+}
+
+// Status returns the active code without its value, or go_verification.ErrCodeNotFound.
+func (c *Client) Status(ctx context.Context, username, scope string) (*go_verification.VerificationCode, error) {
+	var trailer metadata.MD
+	response, err := c.client.Status(ctx, &verificationpb.StatusRequest{Username: username, Scope: scope}, grpc.Trailer(&trailer))
+	if err != nil {
+		return nil, fromStatus(err, trailer)
+	}
+	if !response.GetActive() {
+		return nil, go_verification.ErrCodeNotFound
+	}
+	return &go_verification.VerificationCode{
+		Username:    username,
+		Scope:       scope,
+		ExpiredAt:   response.GetExpiresAt().AsTime(),
+		ExpireAfter: int(response.GetExpireAfter()),
+	}, nil
+}
+
+func toVerificationCode(response *verificationpb.CodeResponse) *go_verification.VerificationCode {
+	code := &go_verification.VerificationCode{
+		Username:    response.GetUsername(),
+		Scope:       response.GetScope(),
+		Code:        response.GetCode(),
+		ExpiredAt:   response.GetExpiresAt().AsTime(),
+		ExpireAfter: int(response.GetExpireAfter()),
+	}
+	if response.GetNextResendAt() != nil {
+		code.NextResendAt = response.GetNextResendAt().AsTime()
+	}
+	return code
+}
+
+func fromStatus(err error, trailer metadata.MD) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	switch st.Code() {
+	case codes.NotFound:
+		return go_verification.ErrCodeNotFound
+	case codes.FailedPrecondition:
+		return go_verification.ErrCodeExpired
+	case codes.InvalidArgument:
+		if st.Message() == go_verification.ErrInvalidCode.Error() {
+			return go_verification.ErrInvalidCode
+		}
+		if detail, ok := strings.CutPrefix(st.Message(), go_verification.ErrInvalidBinding.Error()); ok {
+			return fmt.Errorf("%w%s", go_verification.ErrInvalidBinding, detail)
+		}
+		if detail, ok := strings.CutPrefix(st.Message(), go_verification.ErrInvalidKeyComponent.Error()); ok {
+			return fmt.Errorf("%w%s", go_verification.ErrInvalidKeyComponent, detail)
+		}
+	case codes.AlreadyExists:
+		if st.Message() == go_verification.ErrIdempotencyKeyInProgress.Error() {
+			return go_verification.ErrIdempotencyKeyInProgress
+		}
+		return go_verification.ErrIdempotencyKeyReused
+	case codes.PermissionDenied:
+		if st.Message() == go_verification.ErrBindingMismatch.Error() {
+			return go_verification.ErrBindingMismatch
+		}
+		return fmt.Errorf("%w: %s", go_verification.ErrAbuseDetected, st.Message())
+	case codes.ResourceExhausted:
+		limited := &RateLimitError{Message: st.Message()}
+		if values := trailer.Get(RetryAfterTrailer); len(values) > 0 {
+			if seconds, err := strconv.Atoi(values[0]); err == nil {
+				limited.After = time.Duration(seconds) * time.Second
+			}
+		}
+		return limited
+	}
+	return err
+}
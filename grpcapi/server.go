@@ -0,0 +1,252 @@
+// Package grpcapi serves a VerificationCodeHandler as the VerificationService of
+// verificationpb/verification.proto and provides a client for it.
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	go_verification "github.com/milito-78/go-verification"
+	"github.com/milito-78/go-verification/grpcapi/verificationpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// RetryAfterTrailer carries the seconds to wait when a call is rate limited.
+const RetryAfterTrailer = "retry-after"
+
+// IdempotencyKeyMetadata makes retried Generate calls return the first result, clients
+// set it with metadata.AppendToOutgoingContext.
+const IdempotencyKeyMetadata = "idempotency-key"
+
+// IdentityFunc returns the username a call acts for. requested is the username sent
+// in the request, it can be empty.
+type IdentityFunc func(ctx context.Context, requested string) (string, error)
+
+// DeliverFunc sends a generated code to the user, e.g. by SMS or email.
+type DeliverFunc func(ctx context.Context, code *go_verification.VerificationCode) error
+
+// AttributesFunc returns the attributes of a call for the AbuseGuard of the handler.
+type AttributesFunc func(ctx context.Context) go_verification.RequestAttributes
+
+// BindingFunc returns where a call comes from, for scopes with a BindingPolicy.
+type BindingFunc func(ctx context.Context) go_verification.Binding
+
+type Options struct {
+	// Identity defaults to RequestedIdentity.
+	Identity IdentityFunc
+	// Deliver is called after a code is generated or resent. Nil skips delivery.
+	Deliver DeliverFunc
+	// ExposeCode returns the code in responses. Use it only for development.
+	ExposeCode bool
+	// Attributes defaults to PeerAttributes, set it behind a proxy.
+	Attributes AttributesFunc
+	// Binding is recorded with generated codes and presented when verifying them. Nil
+	// binds nothing, so scopes with a BindingPolicy can't be used.
+	Binding BindingFunc
+}
+
+type Server struct {
+	verificationpb.UnimplementedVerificationServiceServer
+	verification *go_verification.VerificationCodeHandler
+	options      Options
+}
+
+// RequestedIdentity trusts the username sent in the request. Use it only behind
+// something that already authenticated the caller for that username.
+func RequestedIdentity(ctx context.Context, requested string) (string, error) {
+	if requested == "" {
+		return "", errors.New("username is required")
+	}
+	return requested, nil
+}
+
+// PeerAttributes returns the IP of the peer and the user-agent metadata.
+func PeerAttributes(ctx context.Context) go_verification.RequestAttributes {
+	var attributes go_verification.RequestAttributes
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if ip, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			attributes.IP = ip
+		}
+	}
+	if values := metadata.ValueFromIncomingContext(ctx, "user-agent"); len(values) > 0 {
+		attributes.UserAgent = values[0]
+	}
+	return attributes
+}
+
+// NewServer wraps verification, register it with verificationpb.RegisterVerificationServiceServer.
+func NewServer(verification *go_verification.VerificationCodeHandler, options *Options) *Server {
+	s := &Server{verification: verification}
+	if options != nil {
+		s.options = *options
+	}
+	if s.options.Identity == nil {
+		s.options.Identity = RequestedIdentity
+	}
+	if s.options.Attributes == nil {
+		s.options.Attributes = PeerAttributes
+	}
+	return s
+}
+
+func (s *Server) Generate(ctx context.Context, request *verificationpb.GenerateRequest) (*verificationpb.CodeResponse, error) {
+	username, err := s.identify(ctx, request.GetUsername(), request.GetScope())
+	if err != nil {
+		return nil, err
+	}
+	options := []go_verification.GenerateOption{go_verification.WithRequestAttributes(s.options.Attributes(ctx))}
+	if values := metadata.ValueFromIncomingContext(ctx, IdempotencyKeyMetadata); len(values) > 0 && values[0] != "" {
+		options = append(options, go_verification.WithIdempotencyKey(values[0]))
+	}
+	if s.options.Binding != nil {
+		options = append(options, go_verification.WithBinding(s.options.Binding(ctx)))
+	}
+	code, err := s.verification.GenerateCode(username, request.GetScope(), options...)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return s.deliver(ctx, code)
+}
+
+func (s *Server) Verify(ctx context.Context, request *verificationpb.VerifyRequest) (*verificationpb.VerifyResponse, error) {
+	username, err := s.identify(ctx, request.GetUsername(), request.GetScope())
+	if err != nil {
+		return nil, err
+	}
+	if request.GetCode() == "" {
+		return nil, status.Error(codes.InvalidArgument, "code is required")
+	}
+	var options []go_verification.CheckOption
+	if s.options.Binding != nil {
+		options = append(options, go_verification.FromBinding(s.options.Binding(ctx)))
+	}
+	verification, err := s.verification.VerifyCode(username, request.GetCode(), request.GetScope(), options...)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	response := &verificationpb.VerifyResponse{Valid: true}
+	if verification.Grant != nil {
+		response.Grant = verification.Grant.Token
+		response.GrantExpiresAt = timestamppb.New(verification.Grant.ExpiredAt)
+	}
+	return response, nil
+}
+
+func (s *Server) Resend(ctx context.Context, request *verificationpb.ResendRequest) (*verificationpb.CodeResponse, error) {
+	username, err := s.identify(ctx, request.GetUsername(), request.GetScope())
+	if err != nil {
+		return nil, err
+	}
+	attributes := go_verification.WithRequestAttributes(s.options.Attributes(ctx))
+	code, err := s.verification.RegenerateCode(username, request.GetScope(), request.GetResetExpireTime(), attributes)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return s.deliver(ctx, code)
+}
+
+func (s *Server) Revoke(ctx context.Context, request *verificationpb.RevokeRequest) (*verificationpb.RevokeResponse, error) {
+	username, err := s.identify(ctx, request.GetUsername(), request.GetScope())
+	if err != nil {
+		return nil, err
+	}
+	if !s.verification.DeleteCode(username, request.GetScope()) {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &verificationpb.RevokeResponse{}, nil
+}
+
+func (s *Server) Status(ctx context.Context, request *verificationpb.StatusRequest) (*verificationpb.StatusResponse, error) {
+	username, err := s.identify(ctx, request.GetUsername(), request.GetScope())
+	if err != nil {
+		return nil, err
+	}
+	code, err := s.verification.GetCode(username, request.GetScope())
+	if errors.Is(err, go_verification.ErrCodeNotFound) {
+		return &verificationpb.StatusResponse{}, nil
+	}
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return &verificationpb.StatusResponse{
+		Active:      true,
+		ExpiresAt:   timestamppb.New(code.ExpiredAt),
+		ExpireAfter: int64(code.ExpireAfter),
+	}, nil
+}
+
+// identify checks the scope and resolves the username of a call.
+func (s *Server) identify(ctx context.Context, requested, scope string) (string, error) {
+	if scope == "" {
+		return "", status.Error(codes.InvalidArgument, "scope is required")
+	}
+	username, err := s.options.Identity(ctx, requested)
+	if err != nil {
+		return "", status.Error(codes.Unauthenticated, err.Error())
+	}
+	return username, nil
+}
+
+func (s *Server) deliver(ctx context.Context, code *go_verification.VerificationCode) (*verificationpb.CodeResponse, error) {
+	if s.options.Deliver != nil {
+		if err := s.options.Deliver(ctx, code); err != nil {
+			return nil, status.Error(codes.Unavailable, "can not deliver the code")
+		}
+	}
+
+	response := &verificationpb.CodeResponse{
+		Username:    code.Username,
+		Scope:       code.Scope,
+		ExpiresAt:   timestamppb.New(code.ExpiredAt),
+		ExpireAfter: int64(code.ExpireAfter),
+	}
+	if s.options.ExposeCode {
+		response.Code = code.Code
+	}
+	if !code.NextResendAt.IsZero() {
+		response.NextResendAt = timestamppb.New(code.NextResendAt)
+	}
+	return response, nil
+}
+
+// toStatus maps the package errors to status codes. Errors with a
+// RetryAfter() time.Duration method are reported as RESOURCE_EXHAUSTED with a
+// RetryAfterTrailer.
+func toStatus(ctx context.Context, err error) error {
+	var limited interface{ RetryAfter() time.Duration }
+	var abuse *go_verification.AbuseError
+	switch {
+	case errors.As(err, &abuse) && abuse.Decision.RetryAfter <= 0:
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.As(err, &limited):
+		seconds := int(math.Ceil(limited.RetryAfter().Seconds()))
+		if seconds < 1 {
+			seconds = 1
+		}
+		_ = grpc.SetTrailer(ctx, metadata.Pairs(RetryAfterTrailer, strconv.Itoa(seconds)))
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, go_verification.ErrCodeNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, go_verification.ErrCodeExpired):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, go_verification.ErrInvalidCode):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, go_verification.ErrIdempotencyKeyReused), errors.Is(err, go_verification.ErrIdempotencyKeyInProgress):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, go_verification.ErrBindingMismatch):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, go_verification.ErrInvalidBinding), errors.Is(err, go_verification.ErrInvalidKeyComponent):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return status.Error(codes.Internal, "internal error")
+	}
+}
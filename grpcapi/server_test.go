@@ -0,0 +1,244 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	go_verification "github.com/milito-78/go-verification"
+	"github.com/milito-78/go-verification/grpcapi/verificationpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type fixedGenerator struct {
+	code string
+}
+
+func (f fixedGenerator) Generate() string {
+	return f.code
+}
+
+type retryError struct {
+	after time.Duration
+}
+
+func (e retryError) Error() string {
+	return "too many requests"
+}
+
+func (e retryError) RetryAfter() time.Duration {
+	return e.after
+}
+
+// limitedRepository fails every save with a retryError.
+type limitedRepository struct {
+	*go_verification.MemoryCodeRepository
+}
+
+func (r limitedRepository) SaveCode(username, code, scope string, expiresTime time.Duration) (*go_verification.VerificationCode, error) {
+	return nil, retryError{after: 90 * time.Second}
+}
+
+func (r limitedRepository) SaveCodeIfAbsent(username, code, scope string, expiresTime time.Duration, metadata map[string]string) (*go_verification.VerificationCode, error) {
+	return nil, retryError{after: 90 * time.Second}
+}
+
+func newTestClient(t *testing.T, repository go_verification.CodeRepositoryInterface, options *Options) *Client {
+	return newTestClientWithConfig(t, repository, &go_verification.Config{ExpiredAfterSec: 5 * time.Minute}, options)
+}
+
+func newTestClientWithConfig(t *testing.T, repository go_verification.CodeRepositoryInterface, config *go_verification.Config, options *Options) *Client {
+	verification, err := go_verification.NewVerificationCodeHandler(fixedGenerator{code: "123456"}, repository, config)
+	if err != nil {
+		t.Fatalf("Failed to create VerificationCodeHandler: %v", err)
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	verificationpb.RegisterVerificationServiceServer(server, NewServer(verification, options))
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return NewClient(conn)
+}
+
+func TestServer_GenerateAndVerify(t *testing.T) {
+	var delivered *go_verification.VerificationCode
+	client := newTestClient(t, go_verification.NewMemoryCodeRepository(), &Options{
+		Deliver: func(ctx context.Context, code *go_verification.VerificationCode) error {
+			delivered = code
+			return nil
+		},
+	})
+	ctx := context.Background()
+
+	code, err := client.Generate(ctx, "testuser", "testscope")
+	if err != nil {
+		t.Fatalf("Generate error: %v", err)
+	}
+	if code.Username != "testuser" || code.Scope != "testscope" || code.ExpireAfter <= 0 {
+		t.Errorf("Unexpected code %+v", code)
+	}
+	if code.Code != "" {
+		t.Error("Expected the code to be hidden")
+	}
+	if delivered == nil || delivered.Code != "123456" {
+		t.Errorf("Expected the code to be delivered, got %+v", delivered)
+	}
+
+	active, err := client.Status(ctx, "testuser", "testscope")
+	if err != nil {
+		t.Fatalf("Status error: %v", err)
+	}
+	if !active.ExpiredAt.Equal(code.ExpiredAt) {
+		t.Errorf("Expected expiry %v, but got %v", code.ExpiredAt, active.ExpiredAt)
+	}
+
+	if _, err := client.Verify(ctx, "testuser", "000000", "testscope"); !errors.Is(err, go_verification.ErrInvalidCode) {
+		t.Errorf("Expected ErrInvalidCode, got %v", err)
+	}
+	if _, err := client.Verify(ctx, "testuser", "123456", "testscope"); err != nil {
+		t.Errorf("Verify error: %v", err)
+	}
+	if _, err := client.Verify(ctx, "otheruser", "123456", "testscope"); !errors.Is(err, go_verification.ErrCodeNotFound) {
+		t.Errorf("Expected ErrCodeNotFound, got %v", err)
+	}
+}
+
+func TestServer_ResendAndRevoke(t *testing.T) {
+	client := newTestClient(t, go_verification.NewMemoryCodeRepository(), &Options{ExposeCode: true})
+	ctx := context.Background()
+
+	if _, err := client.Resend(ctx, "testuser", "testscope", false); !errors.Is(err, go_verification.ErrCodeNotFound) {
+		t.Errorf("Expected ErrCodeNotFound for resend without code, got %v", err)
+	}
+	if _, err := client.Generate(ctx, "testuser", "testscope"); err != nil {
+		t.Fatalf("Generate error: %v", err)
+	}
+	code, err := client.Resend(ctx, "testuser", "testscope", true)
+	if err != nil {
+		t.Fatalf("Resend error: %v", err)
+	}
+	if code.Code != "123456" {
+		t.Errorf("Expected the exposed code, got %q", code.Code)
+	}
+
+	if err := client.Revoke(ctx, "testuser", "testscope"); err != nil {
+		t.Fatalf("Revoke error: %v", err)
+	}
+	if _, err := client.Status(ctx, "testuser", "testscope"); !errors.Is(err, go_verification.ErrCodeNotFound) {
+		t.Errorf("Expected the code to be revoked, got %v", err)
+	}
+}
+
+func TestServer_Errors(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("missing scope", func(t *testing.T) {
+		client := newTestClient(t, go_verification.NewMemoryCodeRepository(), nil)
+		_, err := client.Generate(ctx, "testuser", "")
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument, got %v", err)
+		}
+	})
+
+	t.Run("identity", func(t *testing.T) {
+		client := newTestClient(t, go_verification.NewMemoryCodeRepository(), nil)
+		_, err := client.Generate(ctx, "", "testscope")
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("Expected Unauthenticated, got %v", err)
+		}
+	})
+
+	t.Run("delivery", func(t *testing.T) {
+		client := newTestClient(t, go_verification.NewMemoryCodeRepository(), &Options{
+			Deliver: func(ctx context.Context, code *go_verification.VerificationCode) error {
+				return errors.New("sms gateway down")
+			},
+		})
+		_, err := client.Generate(ctx, "testuser", "testscope")
+		if status.Code(err) != codes.Unavailable {
+			t.Errorf("Expected Unavailable, got %v", err)
+		}
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		client := newTestClient(t, limitedRepository{go_verification.NewMemoryCodeRepository()}, nil)
+		_, err := client.Generate(ctx, "testuser", "testscope")
+		var limited *RateLimitError
+		if !errors.As(err, &limited) {
+			t.Fatalf("Expected RateLimitError, got %v", err)
+		}
+		if limited.RetryAfter() != 90*time.Second {
+			t.Errorf("Expected retry after 90s, but got %v", limited.RetryAfter())
+		}
+	})
+}
+
+func TestServer_Binding(t *testing.T) {
+	client := newTestClientWithConfig(t, go_verification.NewMemoryCodeRepository(), &go_verification.Config{
+		Scopes: map[string]go_verification.ScopePolicy{"login": {Binding: go_verification.BindingPolicy{Session: true}}},
+	}, &Options{
+		Binding: func(ctx context.Context) go_verification.Binding {
+			var binding go_verification.Binding
+			if values := metadata.ValueFromIncomingContext(ctx, "session"); len(values) > 0 {
+				binding.Session = values[0]
+			}
+			return binding
+		},
+	})
+	session := func(id string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "session", id)
+	}
+
+	if _, err := client.Generate(context.Background(), "testuser", "login"); !errors.Is(err, go_verification.ErrInvalidBinding) {
+		t.Errorf("Expected ErrInvalidBinding without a session, got %v", err)
+	}
+	if _, err := client.Generate(session("s1"), "testuser", "login"); err != nil {
+		t.Fatalf("Generate error: %v", err)
+	}
+	if _, err := client.Verify(session("s2"), "testuser", "123456", "login"); !errors.Is(err, go_verification.ErrBindingMismatch) {
+		t.Errorf("Expected ErrBindingMismatch from another session, got %v", err)
+	}
+	if _, err := client.Verify(session("s1"), "testuser", "123456", "login"); err != nil {
+		t.Errorf("Verify error: %v", err)
+	}
+}
+
+func TestServer_ResendAbuseGuard(t *testing.T) {
+	repository := go_verification.NewMemoryCodeRepository()
+	guard, _ := go_verification.NewVelocityGuard(repository, go_verification.VelocityOptions{
+		DenyList: map[string][]string{go_verification.AttributeASN: {"AS64496"}},
+	})
+	client := newTestClientWithConfig(t, repository, &go_verification.Config{ExpiredAfterSec: 5 * time.Minute, AbuseGuard: guard}, &Options{
+		Attributes: func(ctx context.Context) go_verification.RequestAttributes {
+			return go_verification.RequestAttributes{ASN: "AS64496"}
+		},
+	})
+
+	_, _ = repository.SaveCode("testuser", "000000", "testscope", time.Minute)
+	if _, err := client.Resend(context.Background(), "testuser", "testscope", false); !errors.Is(err, go_verification.ErrAbuseDetected) {
+		t.Errorf("Expected ErrAbuseDetected, got %v", err)
+	}
+}
@@ -0,0 +1,4 @@
+// Package verificationpb holds the generated code of verification.proto.
+package verificationpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative verification.proto
@@ -0,0 +1,321 @@
+// Package httpapi exposes a VerificationCodeHandler as JSON endpoints:
+//
+//	POST   /codes         generate a code
+//	POST   /codes/verify  check a code
+//	POST   /codes/resend  regenerate a code
+//	DELETE /codes         delete a code
+//
+// Mount it under any path with http.StripPrefix.
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	go_verification "github.com/milito-78/go-verification"
+)
+
+const maxBodySize = 1 << 20
+
+// IdempotencyKeyHeader makes retried generate requests return the first result.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdentityFunc returns the username a request acts for. requested is the username
+// sent in the request, it can be empty.
+type IdentityFunc func(r *http.Request, requested string) (string, error)
+
+// DeliverFunc sends a generated code to the user, e.g. by SMS or email.
+type DeliverFunc func(r *http.Request, code *go_verification.VerificationCode) error
+
+// AttributesFunc returns the attributes of a request for the AbuseGuard of the handler.
+type AttributesFunc func(r *http.Request) go_verification.RequestAttributes
+
+// BindingFunc returns where a request comes from, for scopes with a BindingPolicy.
+type BindingFunc func(r *http.Request) go_verification.Binding
+
+type Options struct {
+	// Identity defaults to RequestedIdentity.
+	Identity IdentityFunc
+	// Deliver is called after a code is generated or resent. Nil skips delivery.
+	Deliver DeliverFunc
+	// ExposeCode returns the code in responses. Use it only for development.
+	ExposeCode bool
+	// Attributes defaults to RemoteAttributes, set it behind a proxy.
+	Attributes AttributesFunc
+	// Binding is recorded with generated codes and presented when verifying them. Nil
+	// binds nothing, so scopes with a BindingPolicy can't be used.
+	Binding BindingFunc
+}
+
+type Request struct {
+	Username        string `json:"username"`
+	Scope           string `json:"scope"`
+	Code            string `json:"code,omitempty"`
+	ResetExpireTime bool   `json:"reset_expire_time,omitempty"`
+}
+
+type CodeResponse struct {
+	Username    string    `json:"username"`
+	Scope       string    `json:"scope"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	ExpireAfter int       `json:"expire_after"`
+	Code        string    `json:"code,omitempty"`
+	// NextResendAt is set when the handler has a resend backoff.
+	NextResendAt *time.Time `json:"next_resend_at,omitempty"`
+}
+
+type VerifyResponse struct {
+	Valid bool `json:"valid"`
+	// Grant is set when the handler has a GrantIssuer, send it with the next request.
+	Grant          string     `json:"grant,omitempty"`
+	GrantExpiresAt *time.Time `json:"grant_expires_at,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+type Handler struct {
+	verification *go_verification.VerificationCodeHandler
+	options      Options
+}
+
+// RequestedIdentity trusts the username sent in the request. Use it only behind
+// something that already authenticated the caller for that username.
+func RequestedIdentity(r *http.Request, requested string) (string, error) {
+	if requested == "" {
+		return "", errors.New("username is required")
+	}
+	return requested, nil
+}
+
+// RemoteAttributes returns the IP of the connection and the User-Agent header.
+func RemoteAttributes(r *http.Request) go_verification.RequestAttributes {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return go_verification.RequestAttributes{IP: ip, UserAgent: r.UserAgent()}
+}
+
+func NewHandler(verification *go_verification.VerificationCodeHandler, options *Options) *Handler {
+	h := &Handler{verification: verification}
+	if options != nil {
+		h.options = *options
+	}
+	if h.options.Identity == nil {
+		h.options.Identity = RequestedIdentity
+	}
+	if h.options.Attributes == nil {
+		h.options.Attributes = RemoteAttributes
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/codes":
+		switch r.Method {
+		case http.MethodPost:
+			h.generate(w, r)
+		case http.MethodDelete:
+			h.delete(w, r)
+		default:
+			h.methodNotAllowed(w, "POST, DELETE")
+		}
+	case "/codes/verify":
+		if r.Method != http.MethodPost {
+			h.methodNotAllowed(w, "POST")
+			return
+		}
+		h.verify(w, r)
+	case "/codes/resend":
+		if r.Method != http.MethodPost {
+			h.methodNotAllowed(w, "POST")
+			return
+		}
+		h.resend(w, r)
+	default:
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "not_found", Message: "unknown endpoint"})
+	}
+}
+
+func (h *Handler) generate(w http.ResponseWriter, r *http.Request) {
+	request, ok := h.decode(w, r)
+	if !ok {
+		return
+	}
+	options := []go_verification.GenerateOption{go_verification.WithRequestAttributes(h.options.Attributes(r))}
+	if key := r.Header.Get(IdempotencyKeyHeader); key != "" {
+		options = append(options, go_verification.WithIdempotencyKey(key))
+	}
+	if h.options.Binding != nil {
+		options = append(options, go_verification.WithBinding(h.options.Binding(r)))
+	}
+	code, err := h.verification.GenerateCode(request.Username, request.Scope, options...)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.deliver(w, r, code)
+}
+
+func (h *Handler) resend(w http.ResponseWriter, r *http.Request) {
+	request, ok := h.decode(w, r)
+	if !ok {
+		return
+	}
+	attributes := go_verification.WithRequestAttributes(h.options.Attributes(r))
+	code, err := h.verification.RegenerateCode(request.Username, request.Scope, request.ResetExpireTime, attributes)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.deliver(w, r, code)
+}
+
+func (h *Handler) verify(w http.ResponseWriter, r *http.Request) {
+	request, ok := h.decode(w, r)
+	if !ok {
+		return
+	}
+	if request.Code == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "bad_request", Message: "code is required"})
+		return
+	}
+	var options []go_verification.CheckOption
+	if h.options.Binding != nil {
+		options = append(options, go_verification.FromBinding(h.options.Binding(r)))
+	}
+	verification, err := h.verification.VerifyCode(request.Username, request.Code, request.Scope, options...)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	response := VerifyResponse{Valid: true}
+	if verification.Grant != nil {
+		response.Grant = verification.Grant.Token
+		response.GrantExpiresAt = &verification.Grant.ExpiredAt
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request) {
+	request, ok := h.decode(w, r)
+	if !ok {
+		return
+	}
+	if !h.verification.DeleteCode(request.Username, request.Scope) {
+		h.writeError(w, errors.New("can not delete code"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) deliver(w http.ResponseWriter, r *http.Request, code *go_verification.VerificationCode) {
+	if h.options.Deliver != nil {
+		if err := h.options.Deliver(r, code); err != nil {
+			writeJSON(w, http.StatusBadGateway, ErrorResponse{Error: "delivery_failed", Message: "can not deliver the code"})
+			return
+		}
+	}
+
+	response := CodeResponse{
+		Username:    code.Username,
+		Scope:       code.Scope,
+		ExpiresAt:   code.ExpiredAt,
+		ExpireAfter: code.ExpireAfter,
+	}
+	if h.options.ExposeCode {
+		response.Code = code.Code
+	}
+	if !code.NextResendAt.IsZero() {
+		response.NextResendAt = &code.NextResendAt
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// decode reads the JSON body, falling back to query parameters for empty fields, and
+// resolves the username. It writes the error response itself.
+func (h *Handler) decode(w http.ResponseWriter, r *http.Request) (Request, bool) {
+	var request Request
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err := decoder.Decode(&request); err != nil && err != io.EOF {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "bad_request", Message: "invalid JSON body"})
+		return request, false
+	}
+
+	query := r.URL.Query()
+	if request.Username == "" {
+		request.Username = query.Get("username")
+	}
+	if request.Scope == "" {
+		request.Scope = query.Get("scope")
+	}
+	if request.Scope == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "bad_request", Message: "scope is required"})
+		return request, false
+	}
+
+	username, err := h.options.Identity(r, request.Username)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "unauthorized", Message: err.Error()})
+		return request, false
+	}
+	request.Username = username
+	return request, true
+}
+
+func (h *Handler) methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method_not_allowed", Message: "method not allowed"})
+}
+
+// writeError maps the package errors to status codes. Errors with a
+// RetryAfter() time.Duration method are reported as 429 with a Retry-After header.
+func (h *Handler) writeError(w http.ResponseWriter, err error) {
+	var limited interface{ RetryAfter() time.Duration }
+	var abuse *go_verification.AbuseError
+	switch {
+	case errors.As(err, &abuse) && abuse.Decision.RetryAfter <= 0:
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "abuse_detected", Message: err.Error()})
+	case errors.As(err, &limited):
+		seconds := int(math.Ceil(limited.RetryAfter().Seconds()))
+		if seconds < 1 {
+			seconds = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		writeJSON(w, http.StatusTooManyRequests, ErrorResponse{Error: "rate_limited", Message: err.Error()})
+	case errors.Is(err, go_verification.ErrCodeNotFound):
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "code_not_found", Message: err.Error()})
+	case errors.Is(err, go_verification.ErrCodeExpired):
+		writeJSON(w, http.StatusGone, ErrorResponse{Error: "code_expired", Message: err.Error()})
+	case errors.Is(err, go_verification.ErrInvalidCode):
+		writeJSON(w, http.StatusUnprocessableEntity, ErrorResponse{Error: "invalid_code", Message: err.Error()})
+	case errors.Is(err, go_verification.ErrIdempotencyKeyReused):
+		writeJSON(w, http.StatusConflict, ErrorResponse{Error: "idempotency_key_reused", Message: err.Error()})
+	case errors.Is(err, go_verification.ErrIdempotencyKeyInProgress):
+		writeJSON(w, http.StatusConflict, ErrorResponse{Error: "idempotency_key_in_progress", Message: err.Error()})
+	case errors.Is(err, go_verification.ErrBindingMismatch):
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "binding_mismatch", Message: err.Error()})
+	case errors.Is(err, go_verification.ErrInvalidBinding):
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_binding", Message: err.Error()})
+	case errors.Is(err, go_verification.ErrInvalidKeyComponent):
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "bad_request", Message: err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal_error", Message: "internal error"})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,326 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	go_verification "github.com/milito-78/go-verification"
+)
+
+type fixedGenerator struct {
+	code string
+}
+
+func (f fixedGenerator) Generate() string {
+	return f.code
+}
+
+type retryError struct {
+	after time.Duration
+}
+
+func (e retryError) Error() string {
+	return "too many requests"
+}
+
+func (e retryError) RetryAfter() time.Duration {
+	return e.after
+}
+
+func newTestHandler(t *testing.T, options *Options) (*Handler, *go_verification.MemoryCodeRepository) {
+	repository := go_verification.NewMemoryCodeRepository()
+	verification, err := go_verification.NewVerificationCodeHandler(fixedGenerator{code: "123456"}, repository, &go_verification.Config{
+		ExpiredAfterSec: 5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create VerificationCodeHandler: %v", err)
+	}
+	return NewHandler(verification, options), repository
+}
+
+func serve(handler http.Handler, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var reader bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&reader).Encode(body)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, path, &reader))
+	return recorder
+}
+
+func TestHandler_GenerateAndVerify(t *testing.T) {
+	var delivered *go_verification.VerificationCode
+	handler, _ := newTestHandler(t, &Options{
+		Deliver: func(r *http.Request, code *go_verification.VerificationCode) error {
+			delivered = code
+			return nil
+		},
+	})
+
+	response := serve(handler, http.MethodPost, "/codes", Request{Username: "testuser", Scope: "testscope"})
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body)
+	}
+	var code CodeResponse
+	if err := json.NewDecoder(response.Body).Decode(&code); err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if code.Username != "testuser" || code.Scope != "testscope" || code.ExpireAfter <= 0 {
+		t.Errorf("Unexpected response %+v", code)
+	}
+	if code.Code != "" {
+		t.Error("Expected the code to be hidden")
+	}
+	if delivered == nil || delivered.Code != "123456" {
+		t.Errorf("Expected the code to be delivered, got %+v", delivered)
+	}
+
+	response = serve(handler, http.MethodPost, "/codes/verify", Request{Username: "testuser", Scope: "testscope", Code: "654321"})
+	if response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 for a wrong code, got %d", response.Code)
+	}
+
+	response = serve(handler, http.MethodPost, "/codes/verify", Request{Username: "testuser", Scope: "testscope", Code: "123456"})
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body)
+	}
+	var verify VerifyResponse
+	if err := json.NewDecoder(response.Body).Decode(&verify); err != nil || !verify.Valid {
+		t.Errorf("Expected a valid code, got %+v, %v", verify, err)
+	}
+}
+
+func TestHandler_ResendAndDelete(t *testing.T) {
+	handler, repository := newTestHandler(t, &Options{ExposeCode: true})
+
+	response := serve(handler, http.MethodPost, "/codes/resend", Request{Username: "testuser", Scope: "testscope"})
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without a code, got %d", response.Code)
+	}
+
+	_, _ = repository.SaveCode("testuser", "000000", "testscope", time.Minute)
+	response = serve(handler, http.MethodPost, "/codes/resend", Request{Username: "testuser", Scope: "testscope", ResetExpireTime: true})
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body)
+	}
+	var code CodeResponse
+	_ = json.NewDecoder(response.Body).Decode(&code)
+	if code.Code != "123456" {
+		t.Errorf("Expected the new code to be exposed, got %+v", code)
+	}
+
+	response = serve(handler, http.MethodDelete, "/codes?username=testuser&scope=testscope", nil)
+	if response.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", response.Code)
+	}
+	if _, err := repository.GetCode("testuser", "testscope"); err == nil {
+		t.Error("Expected the code to be deleted")
+	}
+}
+
+func TestHandler_Expired(t *testing.T) {
+	handler, repository := newTestHandler(t, nil)
+	_, _ = repository.SaveCode("testuser", "123456", "testscope", time.Minute)
+	handler.verification, _ = go_verification.NewVerificationCodeHandler(fixedGenerator{code: "123456"}, expiredRepository{repository}, &go_verification.Config{})
+
+	response := serve(handler, http.MethodPost, "/codes/verify", Request{Username: "testuser", Scope: "testscope", Code: "123456"})
+	if response.Code != http.StatusGone {
+		t.Errorf("Expected status 410, got %d", response.Code)
+	}
+}
+
+type expiredRepository struct {
+	*go_verification.MemoryCodeRepository
+}
+
+func (e expiredRepository) GetCode(username, scope string) (*go_verification.VerificationCode, error) {
+	code, err := e.MemoryCodeRepository.GetCode(username, scope)
+	if err == nil {
+		code.ExpiredAt = time.Now().Add(-time.Second)
+	}
+	return code, err
+}
+
+func TestHandler_BadRequests(t *testing.T) {
+	handler, _ := newTestHandler(t, &Options{
+		Identity: func(r *http.Request, requested string) (string, error) {
+			if requested == "" {
+				return "", errors.New("not logged in")
+			}
+			return requested, nil
+		},
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		status int
+	}{
+		{"Test unknown path", http.MethodPost, "/unknown", nil, http.StatusNotFound},
+		{"Test wrong method", http.MethodGet, "/codes", nil, http.StatusMethodNotAllowed},
+		{"Test invalid body", http.MethodPost, "/codes", "not an object", http.StatusBadRequest},
+		{"Test missing scope", http.MethodPost, "/codes", Request{Username: "testuser"}, http.StatusBadRequest},
+		{"Test missing code", http.MethodPost, "/codes/verify", Request{Username: "testuser", Scope: "testscope"}, http.StatusBadRequest},
+		{"Test missing identity", http.MethodPost, "/codes", Request{Scope: "testscope"}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if response := serve(handler, tt.method, tt.path, tt.body); response.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, response.Code, response.Body)
+			}
+		})
+	}
+}
+
+func TestHandler_CustomIdentity(t *testing.T) {
+	handler, repository := newTestHandler(t, &Options{
+		Identity: func(r *http.Request, requested string) (string, error) {
+			return r.Header.Get("X-User"), nil
+		},
+	})
+
+	request := httptest.NewRequest(http.MethodPost, "/codes", bytes.NewBufferString(`{"username": "someone-else", "scope": "testscope"}`))
+	request.Header.Set("X-User", "testuser")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	if _, err := repository.GetCode("testuser", "testscope"); err != nil {
+		t.Errorf("Expected the code for the extracted identity, got %v", err)
+	}
+	if _, err := repository.GetCode("someone-else", "testscope"); err == nil {
+		t.Error("Expected no code for the requested username")
+	}
+}
+
+func TestHandler_WriteError(t *testing.T) {
+	handler, _ := newTestHandler(t, nil)
+
+	recorder := httptest.NewRecorder()
+	handler.writeError(recorder, retryError{after: 1500 * time.Millisecond})
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429, got %d", recorder.Code)
+	}
+	if recorder.Header().Get("Retry-After") != "2" {
+		t.Errorf("Expected Retry-After of 2 seconds, got %q", recorder.Header().Get("Retry-After"))
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.writeError(recorder, go_verification.ErrBindingMismatch)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.writeError(recorder, &go_verification.AbuseError{Decision: &go_verification.AbuseDecision{}})
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for deny lists, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.writeError(recorder, errors.New("redis is down"))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %d", recorder.Code)
+	}
+	var body ErrorResponse
+	_ = json.NewDecoder(recorder.Body).Decode(&body)
+	if body.Message != "internal error" {
+		t.Errorf("Expected internal errors to be hidden, got %q", body.Message)
+	}
+}
+
+func TestHandler_DeliveryFailure(t *testing.T) {
+	handler, _ := newTestHandler(t, &Options{
+		Deliver: func(r *http.Request, code *go_verification.VerificationCode) error {
+			return errors.New("sms gateway is down")
+		},
+	})
+
+	if response := serve(handler, http.MethodPost, "/codes", Request{Username: "testuser", Scope: "testscope"}); response.Code != http.StatusBadGateway {
+		t.Errorf("Expected status 502, got %d", response.Code)
+	}
+}
+
+func TestHandler_VerifyWithGrant(t *testing.T) {
+	repository := go_verification.NewMemoryCodeRepository()
+	verification, _ := go_verification.NewVerificationCodeHandler(fixedGenerator{code: "123456"}, repository, &go_verification.Config{
+		GrantIssuer: go_verification.NewStoredGrantIssuer(repository),
+	})
+	handler := NewHandler(verification, nil)
+	_, _ = verification.GenerateCode("testuser", "testscope")
+
+	response := serve(handler, http.MethodPost, "/codes/verify", Request{Username: "testuser", Scope: "testscope", Code: "123456"})
+	var body VerifyResponse
+	_ = json.NewDecoder(response.Body).Decode(&body)
+	if !body.Valid || body.Grant == "" || body.GrantExpiresAt == nil {
+		t.Fatalf("Expected a grant in the response, got %+v", body)
+	}
+
+	if _, err := verification.RedeemGrant(body.Grant, "testscope"); err != nil {
+		t.Errorf("Expected the grant to be redeemable, got %v", err)
+	}
+}
+
+func TestHandler_Binding(t *testing.T) {
+	verification, _ := go_verification.NewVerificationCodeHandler(fixedGenerator{code: "123456"}, go_verification.NewMemoryCodeRepository(), &go_verification.Config{
+		Scopes: map[string]go_verification.ScopePolicy{"login": {Binding: go_verification.BindingPolicy{Session: true}}},
+	})
+	handler := NewHandler(verification, &Options{
+		Binding: func(r *http.Request) go_verification.Binding {
+			return go_verification.Binding{Session: r.Header.Get("X-Session")}
+		},
+	})
+	request := func(path, session string, body Request) *httptest.ResponseRecorder {
+		var reader bytes.Buffer
+		_ = json.NewEncoder(&reader).Encode(body)
+		r := httptest.NewRequest(http.MethodPost, path, &reader)
+		r.Header.Set("X-Session", session)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	if response := request("/codes", "", Request{Username: "testuser", Scope: "login"}); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a session, got %d: %s", response.Code, response.Body)
+	}
+	if response := request("/codes", "s1", Request{Username: "testuser", Scope: "login"}); response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body)
+	}
+	if response := request("/codes/verify", "s2", Request{Username: "testuser", Scope: "login", Code: "123456"}); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 from another session, got %d", response.Code)
+	}
+	if response := request("/codes/verify", "s1", Request{Username: "testuser", Scope: "login", Code: "123456"}); response.Code != http.StatusOK {
+		t.Errorf("Expected status 200 from the same session, got %d: %s", response.Code, response.Body)
+	}
+}
+
+func TestHandler_ResendAbuseGuard(t *testing.T) {
+	repository := go_verification.NewMemoryCodeRepository()
+	guard, _ := go_verification.NewVelocityGuard(repository, go_verification.VelocityOptions{
+		DenyList: map[string][]string{go_verification.AttributeASN: {"AS64496"}},
+	})
+	verification, _ := go_verification.NewVerificationCodeHandler(fixedGenerator{code: "123456"}, repository, &go_verification.Config{
+		ExpiredAfterSec: 5 * time.Minute,
+		AbuseGuard:      guard,
+	})
+	handler := NewHandler(verification, &Options{
+		Attributes: func(r *http.Request) go_verification.RequestAttributes {
+			return go_verification.RequestAttributes{ASN: "AS64496"}
+		},
+	})
+
+	_, _ = repository.SaveCode("testuser", "000000", "testscope", time.Minute)
+	response := serve(handler, http.MethodPost, "/codes/resend", Request{Username: "testuser", Scope: "testscope"})
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d: %s", response.Code, response.Body)
+	}
+	if code, _ := repository.GetCode("testuser", "testscope"); code == nil || code.Code != "000000" {
+		t.Errorf("Expected the code to be kept, got %+v", code)
+	}
+}
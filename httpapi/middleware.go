@@ -0,0 +1,116 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	go_verification "github.com/milito-78/go-verification"
+)
+
+// DefaultGrantHeader is where RequireGrant looks for the grant token by default,
+// "Authorization: Bearer <token>" is accepted too.
+const DefaultGrantHeader = "X-Verification-Grant"
+
+type grantContextKey struct{}
+
+// GrantChecker checks grant tokens, VerificationCodeHandler implements it.
+type GrantChecker interface {
+	RedeemGrant(token, scope string) (*go_verification.Grant, error)
+	PeekGrant(token, scope string) (*go_verification.Grant, error)
+}
+
+type GrantOptions struct {
+	// Header defaults to DefaultGrantHeader.
+	Header string
+	// Cookie is read when the header is empty.
+	Cookie string
+	// Bearer also reads the token from an Authorization: Bearer header. Leave it off when
+	// the same requests carry other bearer tokens, e.g. access tokens.
+	Bearer bool
+	// Consume redeems the grant so it can't be used again, otherwise it stays valid
+	// until it expires or is redeemed.
+	Consume bool
+	// Authorize can refuse a valid grant, e.g. when it belongs to another user than the
+	// logged in one. Its error is answered with 403.
+	Authorize func(r *http.Request, grant *go_verification.Grant) error
+}
+
+// RequireGrant protects next with a grant for scope. Requests without a token get 401,
+// invalid tokens or refused grants get 403. The grant is available to next with
+// GrantFromContext and UsernameFromContext.
+func RequireGrant(checker GrantChecker, scope string, options *GrantOptions, next http.Handler) http.Handler {
+	opts := GrantOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.Header == "" {
+		opts.Header = DefaultGrantHeader
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := grantToken(r, opts)
+		if token == "" {
+			if opts.Bearer {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="verification", scope="`+scope+`"`)
+			}
+			writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "grant_required", Message: "a verification grant is required"})
+			return
+		}
+
+		var grant *go_verification.Grant
+		var err error
+		if opts.Consume {
+			grant, err = checker.RedeemGrant(token, scope)
+		} else {
+			grant, err = checker.PeekGrant(token, scope)
+		}
+		if err != nil {
+			if errors.Is(err, go_verification.ErrInvalidGrant) {
+				writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "invalid_grant", Message: err.Error()})
+			} else {
+				writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal_error", Message: "internal error"})
+			}
+			return
+		}
+		if opts.Authorize != nil {
+			if err := opts.Authorize(r, grant); err != nil {
+				writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "forbidden", Message: err.Error()})
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), grantContextKey{}, grant)))
+	})
+}
+
+// GrantFromContext returns the grant RequireGrant accepted for the request.
+func GrantFromContext(ctx context.Context) (*go_verification.Grant, bool) {
+	grant, ok := ctx.Value(grantContextKey{}).(*go_verification.Grant)
+	return grant, ok
+}
+
+// UsernameFromContext returns the verified username of the request.
+func UsernameFromContext(ctx context.Context) (string, bool) {
+	grant, ok := GrantFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return grant.Username, true
+}
+
+func grantToken(r *http.Request, opts GrantOptions) string {
+	if token := strings.TrimSpace(r.Header.Get(opts.Header)); token != "" {
+		return token
+	}
+	if authorization := r.Header.Get("Authorization"); opts.Bearer && strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	}
+	if opts.Cookie != "" {
+		if cookie, err := r.Cookie(opts.Cookie); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
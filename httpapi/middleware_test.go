@@ -0,0 +1,168 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	go_verification "github.com/milito-78/go-verification"
+)
+
+func newGrantHandler(t *testing.T) *go_verification.VerificationCodeHandler {
+	repository := go_verification.NewMemoryCodeRepository()
+	verification, err := go_verification.NewVerificationCodeHandler(fixedGenerator{code: "123456"}, repository, &go_verification.Config{
+		ExpiredAfterSec: 5 * time.Minute,
+		GrantIssuer:     go_verification.NewStoredGrantIssuer(repository),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create VerificationCodeHandler: %v", err)
+	}
+	return verification
+}
+
+func issueGrant(t *testing.T, verification *go_verification.VerificationCodeHandler, username, scope string) string {
+	if _, err := verification.GenerateCode(username, scope); err != nil {
+		t.Fatalf("GenerateCode error: %v", err)
+	}
+	result, err := verification.VerifyCode(username, "123456", scope)
+	if err != nil {
+		t.Fatalf("VerifyCode error: %v", err)
+	}
+	return result.Grant.Token
+}
+
+func protected(t *testing.T, verification *go_verification.VerificationCodeHandler, options *GrantOptions) http.Handler {
+	return RequireGrant(verification, "forget-password", options, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, ok := UsernameFromContext(r.Context())
+		if !ok {
+			t.Error("Expected the username in the context")
+		}
+		_, _ = w.Write([]byte(username))
+	}))
+}
+
+func TestRequireGrant(t *testing.T) {
+	verification := newGrantHandler(t)
+	handler := protected(t, verification, nil)
+
+	t.Run("without token", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/password", nil))
+		if response.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401, got %d", response.Code)
+		}
+		if response.Header().Get("WWW-Authenticate") != "" {
+			t.Error("Expected no WWW-Authenticate header without bearer tokens")
+		}
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/password", nil)
+		request.Header.Set(DefaultGrantHeader, "unknown")
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		if response.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", response.Code)
+		}
+	})
+
+	t.Run("other scope", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/password", nil)
+		request.Header.Set(DefaultGrantHeader, issueGrant(t, verification, "testuser", "login"))
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		if response.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", response.Code)
+		}
+	})
+
+	t.Run("valid token is reusable", func(t *testing.T) {
+		token := issueGrant(t, verification, "testuser", "forget-password")
+		for i := 0; i < 2; i++ {
+			request := httptest.NewRequest(http.MethodPost, "/password", nil)
+			request.Header.Set(DefaultGrantHeader, token)
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+			if response.Code != http.StatusOK || response.Body.String() != "testuser" {
+				t.Errorf("Expected status 200 for testuser, got %d: %s", response.Code, response.Body)
+			}
+		}
+	})
+
+	t.Run("bearer token is ignored", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/password", nil)
+		request.Header.Set("Authorization", "Bearer "+issueGrant(t, verification, "testuser", "forget-password"))
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		if response.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401, got %d", response.Code)
+		}
+	})
+}
+
+func TestRequireGrant_Bearer(t *testing.T) {
+	verification := newGrantHandler(t)
+	handler := protected(t, verification, &GrantOptions{Bearer: true})
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/password", nil))
+	if response.Header().Get("WWW-Authenticate") == "" {
+		t.Error("Expected a WWW-Authenticate header")
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/password", nil)
+	request.Header.Set("Authorization", "Bearer "+issueGrant(t, verification, "testuser", "forget-password"))
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	if response.Code != http.StatusOK || response.Body.String() != "testuser" {
+		t.Errorf("Expected status 200 for testuser, got %d: %s", response.Code, response.Body)
+	}
+}
+
+func TestRequireGrant_Consume(t *testing.T) {
+	verification := newGrantHandler(t)
+	handler := protected(t, verification, &GrantOptions{Cookie: "grant", Consume: true})
+	token := issueGrant(t, verification, "testuser", "forget-password")
+
+	for i, expected := range []int{http.StatusOK, http.StatusForbidden} {
+		request := httptest.NewRequest(http.MethodPost, "/password", nil)
+		request.AddCookie(&http.Cookie{Name: "grant", Value: token})
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		if response.Code != expected {
+			t.Errorf("Request %d: expected status %d, got %d", i, expected, response.Code)
+		}
+	}
+}
+
+func TestRequireGrant_Authorize(t *testing.T) {
+	verification := newGrantHandler(t)
+	handler := protected(t, verification, &GrantOptions{
+		Authorize: func(r *http.Request, grant *go_verification.Grant) error {
+			if grant.Username != r.URL.Query().Get("user") {
+				return errors.New("grant belongs to another user")
+			}
+			return nil
+		},
+	})
+	token := issueGrant(t, verification, "testuser", "forget-password")
+
+	tests := []struct {
+		user     string
+		expected int
+	}{
+		{"testuser", http.StatusOK},
+		{"otheruser", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodPost, "/password?user="+test.user, nil)
+		request.Header.Set(DefaultGrantHeader, token)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		if response.Code != test.expected {
+			t.Errorf("Expected status %d for %s, got %d", test.expected, test.user, response.Code)
+		}
+	}
+}
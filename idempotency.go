@@ -0,0 +1,126 @@
+package go_verification
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrIdempotencyKeyReused     = errors.New("idempotency key was used for another request")
+	ErrIdempotencyKeyInProgress = errors.New("a request with the idempotency key is in progress")
+)
+
+// idempotencyReservation is how long a key stays reserved by a call that never finished.
+const idempotencyReservation = time.Minute
+
+// ConditionalSaver is implemented by repositories that can save a code only when
+// username has none for scope, so concurrent GenerateCode calls agree on one code.
+// RedisCodeRepository, MemoryCodeRepository and EncryptedCodeRepository implement it.
+type ConditionalSaver interface {
+	// SaveCodeIfAbsent saves code unless there is one, and returns the code that won.
+	SaveCodeIfAbsent(username, code, scope string, expiresTime time.Duration, metadata map[string]string) (*VerificationCode, error)
+}
+
+// IdempotencyStore keeps the results of GenerateCode calls made with WithIdempotencyKey.
+// RedisCodeRepository and MemoryCodeRepository implement it, EncryptedCodeRepository
+// seals the results before forwarding them.
+type IdempotencyStore interface {
+	// ReserveIdempotencyKey reserves key for ttl unless it is reserved or has a result,
+	// and reports whether it did. It must be atomic.
+	ReserveIdempotencyKey(key string, ttl time.Duration) (bool, error)
+	// ReleaseIdempotencyKey removes the reservation of key when it has no result.
+	ReleaseIdempotencyKey(key string) error
+	// SaveIdempotentResult saves code under key for ttl unless key has a result, the
+	// result that won is returned.
+	SaveIdempotentResult(key string, code *VerificationCode, ttl time.Duration) (*VerificationCode, error)
+	// GetIdempotentResult returns the result of key, ErrIdempotencyKeyInProgress while it
+	// is reserved, or ErrCodeNotFound.
+	GetIdempotentResult(key string) (*VerificationCode, error)
+}
+
+// WithIdempotencyKey makes retries of a GenerateCode call with the same key, username
+// and scope return the first result without sending again, until the code expires.
+// Reusing a key with other metadata fails with ErrIdempotencyKeyReused, and using it
+// while the first call is in progress with ErrIdempotencyKeyInProgress.
+func WithIdempotencyKey(key string) GenerateOption {
+	return func(options *generateOptions) {
+		options.idempotencyKey = key
+	}
+}
+
+// reserveIdempotencyKey reserves the idempotency key of options before anything is sent.
+// When an earlier call has it, its result is returned, or ErrIdempotencyKeyInProgress.
+func (v *VerificationCodeHandler) reserveIdempotencyKey(username, scope string, options generateOptions) (*VerificationCode, error) {
+	if !supports[IdempotencyStore](v.repository) {
+		return nil, errors.New("repository does not support idempotency keys")
+	}
+	store := v.repository.(IdempotencyStore)
+	key := idempotencyKey(username, scope, options.idempotencyKey)
+	reserved, err := store.ReserveIdempotencyKey(key, idempotencyReservation)
+	if err != nil || reserved {
+		return nil, err
+	}
+	result, err := store.GetIdempotentResult(key)
+	if errors.Is(err, ErrCodeNotFound) {
+		// the earlier call released the key meanwhile, the caller may retry
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	if err != nil {
+		return nil, err
+	}
+	return checkIdempotentResult(result, options)
+}
+
+// releaseIdempotencyKey lets a retry of a failed call run again.
+func (v *VerificationCodeHandler) releaseIdempotencyKey(username, scope string, options generateOptions) {
+	_ = v.repository.(IdempotencyStore).ReleaseIdempotencyKey(idempotencyKey(username, scope, options.idempotencyKey))
+}
+
+// saveIdempotentResult saves code as the result of the idempotency key of options and
+// returns the result that won.
+func (v *VerificationCodeHandler) saveIdempotentResult(code *VerificationCode, options generateOptions) (*VerificationCode, error) {
+	store := v.repository.(IdempotencyStore)
+	result, err := store.SaveIdempotentResult(idempotencyKey(code.Username, code.Scope, options.idempotencyKey), code, time.Until(code.ExpiredAt))
+	if err != nil {
+		return nil, err
+	}
+	return checkIdempotentResult(result, options)
+}
+
+func checkIdempotentResult(result *VerificationCode, options generateOptions) (*VerificationCode, error) {
+	if !sameMetadata(result.Metadata, options.metadata) {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if !result.ExpiredAt.After(time.Now()) {
+		return nil, ErrCodeNotFound
+	}
+	result.ExpireAfter = int(time.Until(result.ExpiredAt).Seconds())
+	return result, nil
+}
+
+// saveIfAbsent saves code unless another call saved one meanwhile, then that one is
+// returned. A code with other metadata is replaced.
+func (v *VerificationCodeHandler) saveIfAbsent(username, code, scope string, expiresTime time.Duration, metadata map[string]string) (*VerificationCode, error) {
+	saver, ok := v.repository.(ConditionalSaver)
+	if !ok {
+		return v.save(username, code, scope, expiresTime, metadata)
+	}
+	if len(metadata) > 0 {
+		if _, ok := v.repository.(MetadataSaver); !ok {
+			return nil, ErrMetadataUnsupported
+		}
+	}
+	winner, err := saver.SaveCodeIfAbsent(username, code, scope, expiresTime, metadata)
+	if err != nil {
+		return nil, err
+	}
+	if !sameMetadata(winner.Metadata, metadata) {
+		return v.save(username, code, scope, expiresTime, metadata)
+	}
+	return winner, nil
+}
+
+// idempotencyKey keeps the keys of usernames and scopes apart.
+func idempotencyKey(username, scope, key string) string {
+	return scope + "\x00" + username + "\x00" + key
+}
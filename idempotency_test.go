@@ -0,0 +1,140 @@
+package go_verification
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVerificationCodeHandler_ConcurrentGenerate(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, repository CodeRepositoryInterface) {
+		handler, _ := NewVerificationCodeHandler(&MockCodeGenerator{length: 8}, repository, &Config{})
+		defer repository.DeleteAllCodes("testuser")
+
+		codes := make([]string, 20)
+		var wg sync.WaitGroup
+		for i := range codes {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				code, err := handler.GenerateCode("testuser", "login")
+				if err != nil {
+					t.Errorf("GenerateCode error: %v", err)
+					return
+				}
+				codes[i] = code.Code
+			}(i)
+		}
+		wg.Wait()
+		for _, code := range codes {
+			if code != codes[0] {
+				t.Fatalf("Expected every call to return the same code, got %v", codes)
+			}
+		}
+		if valid, err := handler.CheckCode("testuser", codes[0], "login"); !valid {
+			t.Errorf("Expected the returned code to be the stored one, got %v", err)
+		}
+	})
+}
+
+func TestVerificationCodeHandler_IdempotencyKey(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, repository CodeRepositoryInterface) {
+		config := &Config{ResendBackoff: ResendBackoff{Intervals: []time.Duration{time.Minute}}}
+		handler, _ := NewVerificationCodeHandler(&MockCodeGenerator{length: 8}, repository, config)
+		defer repository.(ResendStore).ResetResend("testuser", "login")
+		defer repository.DeleteAllCodes("testuser")
+
+		key := time.Now().Format(time.RFC3339Nano)
+		first, err := handler.GenerateCode("testuser", "login", WithIdempotencyKey(key))
+		if err != nil {
+			t.Fatalf("GenerateCode error: %v", err)
+		}
+		retried, err := handler.GenerateCode("testuser", "login", WithIdempotencyKey(key))
+		if err != nil || retried.Code != first.Code || !retried.NextResendAt.Equal(first.NextResendAt) {
+			t.Errorf("Expected the retry to return %+v, but got %+v, %v", first, retried, err)
+		}
+		if _, err := handler.GenerateCode("testuser", "login", WithIdempotencyKey(key+"-2")); !errors.Is(err, ErrResendTooSoon) {
+			t.Errorf("Expected another key to send again, got %v", err)
+		}
+		_ = repository.(ResendStore).ResetResend("testuser", "login")
+		if _, err := handler.GenerateCode("testuser", "login", WithIdempotencyKey(key+"-2")); err != nil {
+			t.Errorf("Expected a failed call to release its key, got %v", err)
+		}
+		if _, err := handler.GenerateCode("testuser", "login", WithIdempotencyKey(key), WithMetadata(map[string]string{"a": "b"})); !errors.Is(err, ErrIdempotencyKeyReused) {
+			t.Errorf("Expected ErrIdempotencyKeyReused, got %v", err)
+		}
+		if _, err := handler.GenerateCode("otheruser", "login", WithIdempotencyKey(key)); err != nil {
+			t.Errorf("Expected keys of other usernames to be apart, got %v", err)
+		}
+		repository.DeleteAllCodes("otheruser")
+		_ = repository.(ResendStore).ResetResend("otheruser", "login")
+	})
+
+	handler, _ := NewVerificationCodeHandler(&MockCodeGenerator{defCode: "123456"}, NewMockCodeRepository(), &Config{})
+	if _, err := handler.GenerateCode("testuser", "login", WithIdempotencyKey("key")); err == nil {
+		t.Error("Expected an error for a repository without IdempotencyStore")
+	}
+}
+
+func TestCodeLister_IdempotencyReservation(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, repository CodeRepositoryInterface) {
+		if _, err := repository.SaveCode("testuser", "123456", "login", time.Minute); err != nil {
+			t.Fatalf("SaveCode error: %v", err)
+		}
+		defer repository.DeleteAllCodes("testuser")
+		store := repository.(IdempotencyStore)
+		if reserved, err := store.ReserveIdempotencyKey("key", time.Minute); !reserved {
+			t.Fatalf("ReserveIdempotencyKey error: %v", err)
+		}
+		defer store.ReleaseIdempotencyKey("key")
+
+		page, err := repository.(CodeLister).ListCodes(ListOptions{})
+		if err != nil {
+			t.Fatalf("Expected reservations to be skipped, but got %v", err)
+		}
+		if len(page.Codes) != 1 || page.Codes[0].Username != "testuser" {
+			t.Errorf("Expected the code of testuser, but got %+v", page.Codes)
+		}
+	})
+}
+
+func TestVerificationCodeHandler_ConcurrentIdempotencyKey(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, repository CodeRepositoryInterface) {
+		config := &Config{ResendBackoff: ResendBackoff{Intervals: []time.Duration{time.Minute}}}
+		handler, _ := NewVerificationCodeHandler(&MockCodeGenerator{length: 8}, repository, config)
+		defer repository.(ResendStore).ResetResend("testuser", "login")
+		defer repository.DeleteAllCodes("testuser")
+
+		// a second send would fail the resend backoff
+		key := time.Now().Format(time.RFC3339Nano)
+		codes := make([]string, 20)
+		var wg sync.WaitGroup
+		for i := range codes {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				code, err := handler.GenerateCode("testuser", "login", WithIdempotencyKey(key))
+				if errors.Is(err, ErrIdempotencyKeyInProgress) {
+					return
+				}
+				if err != nil {
+					t.Errorf("GenerateCode error: %v", err)
+					return
+				}
+				codes[i] = code.Code
+			}(i)
+		}
+		wg.Wait()
+
+		first, err := handler.GenerateCode("testuser", "login", WithIdempotencyKey(key))
+		if err != nil {
+			t.Fatalf("GenerateCode error: %v", err)
+		}
+		for _, code := range codes {
+			if code != "" && code != first.Code {
+				t.Fatalf("Expected every call to return %s, got %v", first.Code, codes)
+			}
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package go_verification
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidKeyComponent is returned for a scope or username a KeyBuilder can't keep
+// apart from the others.
+var ErrInvalidKeyComponent = errors.New("scope or username can't be used in a key")
+
+// KeyBuilder builds the Redis keys of RedisCodeRepository.
+type KeyBuilder interface {
+	// Key returns the key of the code of username for scope.
+	Key(prefix, scope, username string) string
+	// Pattern returns a SCAN pattern matching the keys of scope and username, an empty
+	// scope or username matches any.
+	Pattern(prefix, scope, username string) string
+}
+
+// KeyValidator is implemented by a KeyBuilder that can't hold every scope or username.
+// RedisCodeRepository refuses to save or delete codes the builder rejects.
+type KeyValidator interface {
+	Validate(scope, username string) error
+}
+
+// PlainKeyBuilder builds prefix:scope:username keys, the layout RedisCodeRepository
+// always had. The components are not escaped, so scopes and usernames with a ':' are
+// rejected, they would collide with other keys. Patterns are escaped so '*' or '?' in a
+// username only match themselves.
+// The '*' of an empty scope or username also spans ':', so patterns can match the keys of
+// other usernames; RedisCodeRepository checks the record of every key it deletes.
+type PlainKeyBuilder struct{}
+
+func (PlainKeyBuilder) Key(prefix, scope, username string) string {
+	return prefix + ":" + scope + ":" + username
+}
+
+// Validate rejects scopes and usernames with a ':'.
+func (PlainKeyBuilder) Validate(scope, username string) error {
+	if strings.Contains(scope, ":") || strings.Contains(username, ":") {
+		return fmt.Errorf("%w: ':' needs an EscapedKeyBuilder", ErrInvalidKeyComponent)
+	}
+	return nil
+}
+
+func (PlainKeyBuilder) Pattern(prefix, scope, username string) string {
+	return escapeGlob(prefix) + ":" + globOrAny(escapeGlob(scope)) + ":" + globOrAny(escapeGlob(username))
+}
+
+// EscapedKeyBuilder builds prefix:scope:username keys with the scope and username
+// percent-encoded, so they can hold any character without colliding with other keys.
+// With a hash key usernames are replaced by their HMAC-SHA256, keeping emails and phone
+// numbers out of key names.
+type EscapedKeyBuilder struct {
+	usernameHashKey []byte
+}
+
+// NewEscapedKeyBuilder returns a builder that hashes usernames with usernameHashKey, it
+// must be at least 16 bytes. A nil usernameHashKey keeps usernames readable.
+func NewEscapedKeyBuilder(usernameHashKey []byte) (*EscapedKeyBuilder, error) {
+	if usernameHashKey != nil && len(usernameHashKey) < 16 {
+		return nil, errors.New("username hash key must be at least 16 bytes")
+	}
+	return &EscapedKeyBuilder{usernameHashKey: usernameHashKey}, nil
+}
+
+func (e *EscapedKeyBuilder) Key(prefix, scope, username string) string {
+	return prefix + ":" + escapeKeyComponent(scope) + ":" + e.username(username)
+}
+
+func (e *EscapedKeyBuilder) Pattern(prefix, scope, username string) string {
+	var escapedScope, escapedUsername string
+	if scope != "" {
+		escapedScope = escapeKeyComponent(scope)
+	}
+	if username != "" {
+		escapedUsername = e.username(username)
+	}
+	return escapeGlob(prefix) + ":" + globOrAny(escapedScope) + ":" + globOrAny(escapedUsername)
+}
+
+func (e *EscapedKeyBuilder) username(username string) string {
+	if e.usernameHashKey == nil {
+		return escapeKeyComponent(username)
+	}
+	mac := hmac.New(sha256.New, e.usernameHashKey)
+	mac.Write([]byte(username))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// escapeKeyComponent percent-encodes the separator, glob characters, hash tag braces
+// and '%' itself.
+func escapeKeyComponent(component string) string {
+	var builder strings.Builder
+	for i := 0; i < len(component); i++ {
+		switch c := component[i]; c {
+		case '%', ':', '*', '?', '[', ']', '\\', '{', '}':
+			builder.WriteByte('%')
+			builder.WriteByte("0123456789ABCDEF"[c>>4])
+			builder.WriteByte("0123456789ABCDEF"[c&15])
+		default:
+			builder.WriteByte(c)
+		}
+	}
+	return builder.String()
+}
+
+// escapeGlob makes s match only itself in a SCAN pattern.
+func escapeGlob(s string) string {
+	var builder strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			builder.WriteByte('\\')
+		}
+		builder.WriteByte(s[i])
+	}
+	return builder.String()
+}
+
+func globOrAny(s string) string {
+	if s == "" {
+		return "*"
+	}
+	return s
+}
@@ -0,0 +1,68 @@
+package go_verification
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPlainKeyBuilder(t *testing.T) {
+	keys := PlainKeyBuilder{}
+	if key := keys.Key("verification", "login", "user@example.com"); key != "verification:login:user@example.com" {
+		t.Errorf("Expected the legacy layout, but got %q", key)
+	}
+
+	tests := []struct {
+		scope, username string
+		expected        string
+	}{
+		{"login", "user", "verification:login:user"},
+		{"", "user*", `verification:*:user\*`},
+		{"log[in]", "", `verification:log\[in\]:*`},
+		{"", "", "verification:*:*"},
+	}
+	for _, test := range tests {
+		if pattern := keys.Pattern("verification", test.scope, test.username); pattern != test.expected {
+			t.Errorf("Expected pattern %q, but got %q", test.expected, pattern)
+		}
+	}
+
+	if err := keys.Validate("login", "user@example.com"); err != nil {
+		t.Errorf("Expected a plain username to be valid, but got %v", err)
+	}
+	if err := keys.Validate("a", "b:c"); !errors.Is(err, ErrInvalidKeyComponent) {
+		t.Errorf("Expected ErrInvalidKeyComponent, but got %v", err)
+	}
+}
+
+func TestEscapedKeyBuilder(t *testing.T) {
+	keys, err := NewEscapedKeyBuilder(nil)
+	if err != nil {
+		t.Fatalf("NewEscapedKeyBuilder error: %v", err)
+	}
+
+	if key := keys.Key("verification", "log:in", "a:b*"); key != "verification:log%3Ain:a%3Ab%2A" {
+		t.Errorf("Unexpected key %q", key)
+	}
+	if keys.Key("p", "a:b", "c") == keys.Key("p", "a", "b:c") {
+		t.Error("Expected keys with ':' in components not to collide")
+	}
+	if pattern := keys.Pattern("verification", "", "user?"); pattern != "verification:*:user%3F" {
+		t.Errorf("Unexpected pattern %q", pattern)
+	}
+
+	if _, err := NewEscapedKeyBuilder([]byte("short")); err == nil {
+		t.Error("Expected an error for a short hash key")
+	}
+	hashed, _ := NewEscapedKeyBuilder([]byte(strings.Repeat("k", 16)))
+	key := hashed.Key("verification", "login", "+15551234567")
+	if strings.Contains(key, "15551234567") || len(key) != len("verification:login:")+64 {
+		t.Errorf("Expected a hashed username, but got %q", key)
+	}
+	if key != hashed.Key("verification", "login", "+15551234567") {
+		t.Error("Expected hashing to be stable")
+	}
+	if pattern := hashed.Pattern("verification", "", "+15551234567"); !strings.HasSuffix(key, strings.TrimPrefix(pattern, "verification:*")) {
+		t.Errorf("Expected pattern %q to match key %q", pattern, key)
+	}
+}
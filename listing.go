@@ -0,0 +1,82 @@
+package go_verification
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// DefaultListLimit is the page size of ListCodes when ListOptions.Limit is not set.
+const DefaultListLimit = 50
+
+var ErrListingUnsupported = errors.New("repository can not list codes")
+
+// ListOptions selects the codes ListCodes returns. Empty Username or Scope match every
+// username or scope.
+type ListOptions struct {
+	Username string
+	Scope    string
+	// Cursor is the NextCursor of the previous page, empty for the first page.
+	Cursor string
+	// Limit defaults to DefaultListLimit. Redis pages can hold a few more or fewer codes.
+	Limit int
+	// WithCodes returns the codes themselves, they are redacted by default.
+	WithCodes bool
+}
+
+type CodePage struct {
+	Codes []*VerificationCode
+	// NextCursor is empty on the last page.
+	NextCursor string
+}
+
+// CodeLister is implemented by repositories that can enumerate active codes.
+// RedisCodeRepository and MemoryCodeRepository implement it.
+type CodeLister interface {
+	ListCodes(options ListOptions) (*CodePage, error)
+}
+
+// ListCodes returns a page of active codes, ErrListingUnsupported when the repository
+// doesn't implement CodeLister.
+func (v *VerificationCodeHandler) ListCodes(options ListOptions) (*CodePage, error) {
+	lister, ok := v.repository.(CodeLister)
+	if !ok {
+		return nil, ErrListingUnsupported
+	}
+	return lister.ListCodes(options)
+}
+
+func (o ListOptions) limit() int {
+	if o.Limit <= 0 {
+		return DefaultListLimit
+	}
+	return o.Limit
+}
+
+func (o ListOptions) matches(username, scope string) bool {
+	return (o.Username == "" || o.Username == username) && (o.Scope == "" || o.Scope == scope)
+}
+
+func (o ListOptions) redact(code *VerificationCode) *VerificationCode {
+	if !o.WithCodes {
+		code.Code = ""
+	}
+	return code
+}
+
+// encodeListCursor points after the last scope and username of a sorted page.
+func encodeListCursor(scope, username string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(scope + "\x00" + username))
+}
+
+func decodeListCursor(cursor string) (scope, username string, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", errors.New("invalid cursor")
+	}
+	scope, username, ok := strings.Cut(string(data), "\x00")
+	if !ok {
+		return "", "", errors.New("invalid cursor")
+	}
+	return scope, username, nil
+}
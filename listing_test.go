@@ -0,0 +1,27 @@
+package go_verification
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerificationCodeHandler_ListCodes(t *testing.T) {
+	handler, _ := NewVerificationCodeHandler(&MockCodeGenerator{defCode: "123456"}, NewMockCodeRepository(), &Config{})
+	if _, err := handler.ListCodes(ListOptions{}); !errors.Is(err, ErrListingUnsupported) {
+		t.Errorf("Expected ErrListingUnsupported, got %v", err)
+	}
+
+	handler, _ = NewVerificationCodeHandler(&MockCodeGenerator{defCode: "123456"}, NewMemoryCodeRepository(), &Config{ExpiredAfterSec: 5 * time.Minute})
+	_, _ = handler.GenerateCode("testuser", "forget-password")
+	page, err := handler.ListCodes(ListOptions{Scope: "forget-password"})
+	if err != nil {
+		t.Fatalf("ListCodes error: %v", err)
+	}
+	if len(page.Codes) != 1 || page.Codes[0].Username != "testuser" || page.Codes[0].Code != "" {
+		t.Errorf("Expected the redacted code of testuser, but got %+v", page.Codes)
+	}
+	if page.NextCursor != "" {
+		t.Errorf("Expected a single page, but got cursor %q", page.NextCursor)
+	}
+}
@@ -0,0 +1,434 @@
+package go_verification
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryCodeKey struct {
+	username string
+	scope    string
+}
+
+// MemoryCodeRepository keeps codes in the process memory. It is meant for tests and
+// single instance services, codes are lost on restart.
+type MemoryCodeRepository struct {
+	mu       sync.Mutex
+	codes    map[memoryCodeKey]VerificationCode
+	grants   map[string]Grant
+	recovery map[string]map[string]bool
+	previous map[memoryCodeKey][]VerificationCode
+	resends  map[memoryCodeKey]resendState
+	events   map[string][]memoryEvent
+	results  map[string]VerificationCode
+	reserved map[string]time.Time
+	tenants  map[string]*MemoryCodeRepository
+}
+
+func NewMemoryCodeRepository() *MemoryCodeRepository {
+	return &MemoryCodeRepository{
+		codes:    make(map[memoryCodeKey]VerificationCode),
+		grants:   make(map[string]Grant),
+		recovery: make(map[string]map[string]bool),
+		previous: make(map[memoryCodeKey][]VerificationCode),
+		resends:  make(map[memoryCodeKey]resendState),
+		events:   make(map[string][]memoryEvent),
+		results:  make(map[string]VerificationCode),
+		reserved: make(map[string]time.Time),
+	}
+}
+
+// WithTenant returns the repository of tenant, it shares nothing with the others.
+func (m *MemoryCodeRepository) WithTenant(tenant string) (CodeRepositoryInterface, error) {
+	if err := checkTenant(tenant); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.tenants == nil {
+		m.tenants = make(map[string]*MemoryCodeRepository)
+	}
+	repository, ok := m.tenants[tenant]
+	if !ok {
+		repository = NewMemoryCodeRepository()
+		m.tenants[tenant] = repository
+	}
+	return repository, nil
+}
+
+func (m *MemoryCodeRepository) SaveCode(username, code, scope string, expiresTime time.Duration) (*VerificationCode, error) {
+	return m.SaveCodeWithMetadata(username, code, scope, expiresTime, nil)
+}
+
+func (m *MemoryCodeRepository) SaveCodeWithMetadata(username, code, scope string, expiresTime time.Duration, metadata map[string]string) (*VerificationCode, error) {
+	verification := newVerificationCode(username, code, scope, expiresTime, metadata)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.codes[memoryCodeKey{username: username, scope: scope}] = *verification
+	verification.Metadata = copyMetadata(metadata)
+	return verification, nil
+}
+
+func (m *MemoryCodeRepository) SaveCodeIfAbsent(username, code, scope string, expiresTime time.Duration, metadata map[string]string) (*VerificationCode, error) {
+	verification := newVerificationCode(username, code, scope, expiresTime, metadata)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memoryCodeKey{username: username, scope: scope}
+	if winner, ok := m.codes[key]; ok && winner.ExpiredAt.After(time.Now()) {
+		winner.ExpireAfter = int(time.Until(winner.ExpiredAt).Seconds())
+		winner.Metadata = copyMetadata(winner.Metadata)
+		return &winner, nil
+	}
+	m.codes[key] = *verification
+	verification.Metadata = copyMetadata(metadata)
+	return verification, nil
+}
+
+func (m *MemoryCodeRepository) GetCode(username, scope string) (*VerificationCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memoryCodeKey{username: username, scope: scope}
+	verification, ok := m.codes[key]
+	if !ok {
+		return nil, ErrCodeNotFound
+	}
+	if !verification.ExpiredAt.After(time.Now()) {
+		delete(m.codes, key)
+		return nil, ErrCodeNotFound
+	}
+	verification.ExpireAfter = int(time.Until(verification.ExpiredAt).Seconds())
+	verification.Metadata = copyMetadata(verification.Metadata)
+	return &verification, nil
+}
+
+func (m *MemoryCodeRepository) DeleteCode(username, scope string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memoryCodeKey{username: username, scope: scope}
+	delete(m.codes, key)
+	delete(m.previous, key)
+	return true
+}
+
+func (m *MemoryCodeRepository) DeleteAllCodes(username string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key := range m.codes {
+		if key.username == username {
+			delete(m.codes, key)
+		}
+	}
+	for key := range m.previous {
+		if key.username == username {
+			delete(m.previous, key)
+		}
+	}
+	return true
+}
+
+func (m *MemoryCodeRepository) DeleteScope(scope string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted := 0
+	for key, verification := range m.codes {
+		if key.scope != scope {
+			continue
+		}
+		delete(m.codes, key)
+		if verification.ExpiredAt.After(time.Now()) {
+			deleted++
+		}
+	}
+	for key := range m.previous {
+		if key.scope == scope {
+			delete(m.previous, key)
+		}
+	}
+	return deleted, nil
+}
+
+// ListCodes returns the active codes sorted by scope and username.
+func (m *MemoryCodeRepository) ListCodes(options ListOptions) (*CodePage, error) {
+	var afterScope, afterUsername string
+	if options.Cursor != "" {
+		var err error
+		if afterScope, afterUsername, err = decodeListCursor(options.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	var codes []*VerificationCode
+	for key, verification := range m.codes {
+		if !options.matches(key.username, key.scope) || !verification.ExpiredAt.After(time.Now()) {
+			continue
+		}
+		if options.Cursor != "" && (key.scope < afterScope || key.scope == afterScope && key.username <= afterUsername) {
+			continue
+		}
+		verification := verification
+		verification.ExpireAfter = int(time.Until(verification.ExpiredAt).Seconds())
+		verification.Metadata = copyMetadata(verification.Metadata)
+		codes = append(codes, options.redact(&verification))
+	}
+	m.mu.Unlock()
+
+	sort.Slice(codes, func(i, j int) bool {
+		if codes[i].Scope != codes[j].Scope {
+			return codes[i].Scope < codes[j].Scope
+		}
+		return codes[i].Username < codes[j].Username
+	})
+	page := &CodePage{Codes: codes}
+	if limit := options.limit(); len(codes) > limit {
+		page.Codes = codes[:limit]
+		last := page.Codes[limit-1]
+		page.NextCursor = encodeListCursor(last.Scope, last.Username)
+	}
+	return page, nil
+}
+
+func (m *MemoryCodeRepository) SaveGrant(id string, grant *Grant, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := *grant
+	stored.Token = ""
+	stored.ExpiredAt = time.Now().Add(ttl)
+	m.grants[grantKey(id)] = stored
+	return nil
+}
+
+func (m *MemoryCodeRepository) GetGrant(id string) (*Grant, error) {
+	return m.loadGrant(id, false)
+}
+
+func (m *MemoryCodeRepository) TakeGrant(id string) (*Grant, error) {
+	return m.loadGrant(id, true)
+}
+
+func (m *MemoryCodeRepository) loadGrant(id string, take bool) (*Grant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := grantKey(id)
+	grant, ok := m.grants[key]
+	if !ok {
+		return nil, ErrInvalidGrant
+	}
+	if take || !grant.ExpiredAt.After(time.Now()) {
+		delete(m.grants, key)
+	}
+	if !grant.ExpiredAt.After(time.Now()) {
+		return nil, ErrInvalidGrant
+	}
+	return &grant, nil
+}
+
+func (m *MemoryCodeRepository) SaveRecoveryCodes(username string, hashes []string) error {
+	set := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		set[hash] = true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recovery[username] = set
+	return nil
+}
+
+func (m *MemoryCodeRepository) BurnRecoveryCode(username, hash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.recovery[username][hash] {
+		return false, nil
+	}
+	delete(m.recovery[username], hash)
+	return true, nil
+}
+
+func (m *MemoryCodeRepository) CountRecoveryCodes(username string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.recovery[username]), nil
+}
+
+func (m *MemoryCodeRepository) DeleteRecoveryCodes(username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.recovery, username)
+	return nil
+}
+
+func (m *MemoryCodeRepository) PushPreviousCode(code *VerificationCode, limit int) error {
+	previous := *code
+	previous.Metadata = copyMetadata(code.Metadata)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memoryCodeKey{username: code.Username, scope: code.Scope}
+	var codes []VerificationCode
+	for _, verification := range m.previous[key] {
+		if verification.ExpiredAt.After(time.Now()) {
+			codes = append(codes, verification)
+		}
+	}
+	codes = append(codes, previous)
+	if len(codes) > limit {
+		codes = codes[len(codes)-limit:]
+	}
+	m.previous[key] = codes
+	return nil
+}
+
+func (m *MemoryCodeRepository) GetPreviousCodes(username, scope string) ([]*VerificationCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var codes []*VerificationCode
+	for _, verification := range m.previous[memoryCodeKey{username: username, scope: scope}] {
+		if !verification.ExpiredAt.After(time.Now()) {
+			continue
+		}
+		verification := verification
+		verification.ExpireAfter = int(time.Until(verification.ExpiredAt).Seconds())
+		verification.Metadata = copyMetadata(verification.Metadata)
+		codes = append(codes, &verification)
+	}
+	return codes, nil
+}
+
+func (m *MemoryCodeRepository) DeletePreviousCodes(username, scope string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.previous, memoryCodeKey{username: username, scope: scope})
+	return nil
+}
+
+func (m *MemoryCodeRepository) TakeResend(username, scope string, backoff ResendBackoff) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memoryCodeKey{username: username, scope: scope}
+	state, err := backoff.take(m.resends[key], time.Now())
+	if err != nil {
+		return time.Time{}, err
+	}
+	m.resends[key] = state
+	return state.NextAt, nil
+}
+
+func (m *MemoryCodeRepository) ReleaseResend(username, scope string, next time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memoryCodeKey{username: username, scope: scope}
+	if state, ok := m.resends[key].release(next); ok {
+		m.resends[key] = state
+	}
+	return nil
+}
+
+func (m *MemoryCodeRepository) ResetResend(username, scope string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.resends, memoryCodeKey{username: username, scope: scope})
+	return nil
+}
+
+// memoryEvent is an event of a velocity key.
+type memoryEvent struct {
+	id string
+	at time.Time
+}
+
+func (m *MemoryCodeRepository) ReserveEvent(key string, window time.Duration) (string, int, time.Time, error) {
+	event, err := newEventID()
+	if err != nil {
+		return "", 0, time.Time{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	events := append(m.trimEvents(key, window), memoryEvent{id: event, at: time.Now()})
+	m.events[key] = events
+	return event, len(events), events[0].at, nil
+}
+
+func (m *MemoryCodeRepository) ReleaseEvent(key, event string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	events := m.events[key]
+	for i := range events {
+		if events[i].id == event {
+			m.events[key] = append(events[:i:i], events[i+1:]...)
+			break
+		}
+	}
+	if len(m.events[key]) == 0 {
+		delete(m.events, key)
+	}
+	return nil
+}
+
+// trimEvents drops the events of key older than window.
+func (m *MemoryCodeRepository) trimEvents(key string, window time.Duration) []memoryEvent {
+	events := m.events[key]
+	start := time.Now().Add(-window)
+	for len(events) > 0 && !events[0].at.After(start) {
+		events = events[1:]
+	}
+	if len(events) == 0 {
+		delete(m.events, key)
+		return nil
+	}
+	m.events[key] = events
+	return events
+}
+
+func (m *MemoryCodeRepository) ReserveIdempotencyKey(key string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if result, ok := m.results[key]; ok && result.ExpiredAt.After(time.Now()) {
+		return false, nil
+	}
+	if until, ok := m.reserved[key]; ok && until.After(time.Now()) {
+		return false, nil
+	}
+	m.reserved[key] = time.Now().Add(ttl)
+	return true, nil
+}
+
+func (m *MemoryCodeRepository) ReleaseIdempotencyKey(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.reserved, key)
+	return nil
+}
+
+// SaveIdempotentResult keeps code until it expires, ttl is not needed.
+func (m *MemoryCodeRepository) SaveIdempotentResult(key string, code *VerificationCode, ttl time.Duration) (*VerificationCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if result, ok := m.results[key]; ok && result.ExpiredAt.After(time.Now()) {
+		result.Metadata = copyMetadata(result.Metadata)
+		return &result, nil
+	}
+	delete(m.reserved, key)
+	result := *code
+	result.Metadata = copyMetadata(code.Metadata)
+	m.results[key] = result
+	return code, nil
+}
+
+func (m *MemoryCodeRepository) GetIdempotentResult(key string) (*VerificationCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result, ok := m.results[key]
+	if ok && !result.ExpiredAt.After(time.Now()) {
+		delete(m.results, key)
+		ok = false
+	}
+	if !ok {
+		if until, reserved := m.reserved[key]; reserved && until.After(time.Now()) {
+			return nil, ErrIdempotencyKeyInProgress
+		}
+		return nil, ErrCodeNotFound
+	}
+	result.Metadata = copyMetadata(result.Metadata)
+	return &result, nil
+}
@@ -0,0 +1,145 @@
+package go_verification
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryCodeRepository(t *testing.T) {
+	repo := NewMemoryCodeRepository()
+
+	username := "testuser"
+	code := "123456"
+	scope := "test_scope"
+	expiresTime := 10 * time.Minute
+
+	verification, err := repo.SaveCode(username, code, scope, expiresTime)
+	if err != nil {
+		t.Fatalf("SaveCode error: %v", err)
+	}
+	if verification.ExpiredTime != Duration(expiresTime) {
+		t.Fatalf("ExpiredTime is not equals to input value")
+	}
+
+	savedVerification, err := repo.GetCode(username, scope)
+	if err != nil {
+		t.Fatalf("GetCode error: %v", err)
+	}
+	if savedVerification.Code != code {
+		t.Errorf("Expected code to be %s, got %s", code, savedVerification.Code)
+	}
+
+	if deleted := repo.DeleteCode(username, scope); !deleted {
+		t.Error("DeleteCode failed to delete the code")
+	}
+	if _, err = repo.GetCode(username, scope); err == nil {
+		t.Error("GetCode expected to return an error after deletion")
+	}
+}
+
+func TestMemoryCodeRepository_Expired(t *testing.T) {
+	repo := NewMemoryCodeRepository()
+	if _, err := repo.SaveCode("testuser", "123456", "test_scope", -time.Second); err != nil {
+		t.Fatalf("SaveCode error: %v", err)
+	}
+
+	if _, err := repo.GetCode("testuser", "test_scope"); err == nil {
+		t.Error("GetCode expected to return an error for an expired code")
+	}
+}
+
+func TestMemoryCodeRepository_DeleteAllCodes(t *testing.T) {
+	repo := NewMemoryCodeRepository()
+	_, _ = repo.SaveCode("testuser", "123456", "test_scope1", time.Minute)
+	_, _ = repo.SaveCode("testuser", "123456", "test_scope2", time.Minute)
+	_, _ = repo.SaveCode("otheruser", "123456", "test_scope1", time.Minute)
+
+	if deleted := repo.DeleteAllCodes("testuser"); !deleted {
+		t.Error("DeleteAllCodes failed to delete all codes")
+	}
+
+	if _, err := repo.GetCode("testuser", "test_scope1"); err == nil {
+		t.Error("GetCode expected to return an error after deletion")
+	}
+	if _, err := repo.GetCode("testuser", "test_scope2"); err == nil {
+		t.Error("GetCode expected to return an error after deletion")
+	}
+	if _, err := repo.GetCode("otheruser", "test_scope1"); err != nil {
+		t.Errorf("Expected codes of other users to stay, got %v", err)
+	}
+}
+
+func TestMemoryCodeRepository_ListCodes(t *testing.T) {
+	repo := NewMemoryCodeRepository()
+	_, _ = repo.SaveCode("user2", "123456", "test_scope1", time.Minute)
+	_, _ = repo.SaveCode("user1", "123456", "test_scope1", time.Minute)
+	_, _ = repo.SaveCode("user3", "123456", "test_scope1", time.Minute)
+	_, _ = repo.SaveCode("user1", "123456", "test_scope2", time.Minute)
+	_, _ = repo.SaveCode("user4", "123456", "test_scope1", -time.Second)
+
+	t.Run("by scope with pages", func(t *testing.T) {
+		var usernames []string
+		options := ListOptions{Scope: "test_scope1", Limit: 2}
+		for pages := 1; ; pages++ {
+			page, err := repo.ListCodes(options)
+			if err != nil {
+				t.Fatalf("ListCodes error: %v", err)
+			}
+			for _, code := range page.Codes {
+				usernames = append(usernames, code.Username)
+				if code.Code != "" {
+					t.Errorf("Expected the code to be redacted, got %q", code.Code)
+				}
+			}
+			if page.NextCursor == "" {
+				if pages != 2 {
+					t.Errorf("Expected 2 pages, but got %d", pages)
+				}
+				break
+			}
+			options.Cursor = page.NextCursor
+		}
+		if strings.Join(usernames, ",") != "user1,user2,user3" {
+			t.Errorf("Expected user1,user2,user3, but got %v", usernames)
+		}
+	})
+
+	t.Run("by username with codes", func(t *testing.T) {
+		page, err := repo.ListCodes(ListOptions{Username: "user1", WithCodes: true})
+		if err != nil {
+			t.Fatalf("ListCodes error: %v", err)
+		}
+		if len(page.Codes) != 2 || page.Codes[0].Scope != "test_scope1" || page.Codes[1].Scope != "test_scope2" {
+			t.Fatalf("Expected both scopes of user1, but got %+v", page.Codes)
+		}
+		if page.Codes[0].Code != "123456" {
+			t.Errorf("Expected the code, got %q", page.Codes[0].Code)
+		}
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		if _, err := repo.ListCodes(ListOptions{Cursor: "!"}); err == nil {
+			t.Error("Expected an error for an invalid cursor")
+		}
+	})
+}
+
+func TestMemoryCodeRepository_DeleteScope(t *testing.T) {
+	repo := NewMemoryCodeRepository()
+	_, _ = repo.SaveCode("user1", "123456", "test_scope1", time.Minute)
+	_, _ = repo.SaveCode("user2", "123456", "test_scope1", time.Minute)
+	_, _ = repo.SaveCode("user3", "123456", "test_scope1", -time.Second)
+	_, _ = repo.SaveCode("user1", "123456", "test_scope2", time.Minute)
+
+	deleted, err := repo.DeleteScope("test_scope1")
+	if err != nil || deleted != 2 {
+		t.Errorf("Expected 2 deleted codes, but got %d, %v", deleted, err)
+	}
+	if _, err := repo.GetCode("user1", "test_scope1"); err == nil {
+		t.Error("GetCode expected to return an error after deletion")
+	}
+	if _, err := repo.GetCode("user1", "test_scope2"); err != nil {
+		t.Errorf("Expected other scopes to stay, got %v", err)
+	}
+}
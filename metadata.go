@@ -0,0 +1,69 @@
+package go_verification
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrMetadataUnsupported = errors.New("repository does not support metadata")
+
+// MetadataSaver is implemented by repositories that can store metadata with a code.
+// RedisCodeRepository, MemoryCodeRepository and EncryptedCodeRepository implement it.
+type MetadataSaver interface {
+	SaveCodeWithMetadata(username, code, scope string, expiresTime time.Duration, metadata map[string]string) (*VerificationCode, error)
+}
+
+// GenerateOption changes how GenerateCode creates a code.
+type GenerateOption func(*generateOptions)
+
+type generateOptions struct {
+	metadata       map[string]string
+	binding        *Binding
+	attributes     RequestAttributes
+	idempotencyKey string
+}
+
+// WithMetadata stores metadata with the code, e.g. the new email address being verified
+// or the ID of the order being confirmed. It is returned with the code by GetCode and
+// VerifyCode.
+func WithMetadata(metadata map[string]string) GenerateOption {
+	return func(options *generateOptions) {
+		options.metadata = copyMetadata(metadata)
+	}
+}
+
+// save saves code with metadata, repositories without MetadataSaver only get codes
+// without metadata.
+func (v *VerificationCodeHandler) save(username, code, scope string, expiresTime time.Duration, metadata map[string]string) (*VerificationCode, error) {
+	if len(metadata) == 0 {
+		return v.repository.SaveCode(username, code, scope, expiresTime)
+	}
+	saver, ok := v.repository.(MetadataSaver)
+	if !ok {
+		return nil, ErrMetadataUnsupported
+	}
+	return saver.SaveCodeWithMetadata(username, code, scope, expiresTime, metadata)
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		copied[key] = value
+	}
+	return copied
+}
+
+func sameMetadata(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,71 @@
+package go_verification
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerificationCodeHandler_Metadata(t *testing.T) {
+	handler, _ := NewVerificationCodeHandler(&MockCodeGenerator{length: 8}, NewMemoryCodeRepository(), &Config{})
+	metadata := map[string]string{"email": "new@example.com", "ip": "203.0.113.7"}
+
+	code, err := handler.GenerateCode("testuser", "change-email", WithMetadata(metadata))
+	if err != nil {
+		t.Fatalf("GenerateCode error: %v", err)
+	}
+	metadata["email"] = "changed@example.com"
+	if code.Metadata["email"] != "new@example.com" {
+		t.Errorf("Expected the metadata to be copied, got %v", code.Metadata)
+	}
+
+	t.Run("same metadata keeps the code", func(t *testing.T) {
+		again, _ := handler.GenerateCode("testuser", "change-email", WithMetadata(map[string]string{"email": "new@example.com", "ip": "203.0.113.7"}))
+		if again.Code != code.Code {
+			t.Errorf("Expected code %s, but got %s", code.Code, again.Code)
+		}
+	})
+
+	t.Run("regenerate keeps the metadata", func(t *testing.T) {
+		for _, reset := range []bool{false, true} {
+			regenerated, err := handler.RegenerateCode("testuser", "change-email", reset)
+			if err != nil || regenerated.Metadata["email"] != "new@example.com" {
+				t.Errorf("Expected the metadata to be kept with reset %v, got %+v, %v", reset, regenerated, err)
+			}
+		}
+	})
+
+	t.Run("other metadata replaces the code", func(t *testing.T) {
+		other, _ := handler.GenerateCode("testuser", "change-email", WithMetadata(map[string]string{"email": "other@example.com"}))
+		verification, err := handler.VerifyCode("testuser", other.Code, "change-email")
+		if err != nil {
+			t.Fatalf("VerifyCode error: %v", err)
+		}
+		if verification.Code.Metadata["email"] != "other@example.com" || len(verification.Code.Metadata) != 1 {
+			t.Errorf("Expected the metadata of the new code, got %v", verification.Code.Metadata)
+		}
+	})
+
+	t.Run("unsupported repository", func(t *testing.T) {
+		handler, _ := NewVerificationCodeHandler(&MockCodeGenerator{defCode: "123456"}, NewMockCodeRepository(), &Config{})
+		if _, err := handler.GenerateCode("testuser", "login", WithMetadata(map[string]string{"a": "b"})); !errors.Is(err, ErrMetadataUnsupported) {
+			t.Errorf("Expected ErrMetadataUnsupported, got %v", err)
+		}
+		if _, err := handler.GenerateCode("testuser", "login"); err != nil {
+			t.Errorf("Expected codes without metadata to be saved, got %v", err)
+		}
+	})
+}
+
+func TestEncryptedCodeRepository_Metadata(t *testing.T) {
+	inner := NewMemoryCodeRepository()
+	repo := NewEncryptedCodeRepository(inner, testEncryptionKeys(t, "2024"))
+	saved, err := repo.SaveCodeWithMetadata("testuser", "123456", "login", time.Minute, map[string]string{"order": "42"})
+	if err != nil || saved.Code != "123456" {
+		t.Fatalf("Expected the plain code, got %+v, %v", saved, err)
+	}
+	code, err := repo.GetCode("testuser", "login")
+	if err != nil || code.Code != "123456" || code.Metadata["order"] != "42" {
+		t.Errorf("Expected the code with its metadata, got %+v, %v", code, err)
+	}
+}
@@ -0,0 +1,123 @@
+package go_verification
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// effLargeWordList is the EFF large word list (https://www.eff.org/dice) without
+// its four hyphenated words, one word per line.
+//
+//go:embed wordlists/eff_large.txt
+var effLargeWordList string
+
+type Capitalization int
+
+const (
+	LowerCase Capitalization = iota
+	TitleCase
+	UpperCase
+)
+
+type PassphraseGenerator struct {
+	words          []string
+	count          int
+	separator      string
+	capitalization Capitalization
+}
+
+// DefaultWordList returns a copy of the embedded EFF word list.
+func DefaultWordList() []string {
+	return strings.Fields(effLargeWordList)
+}
+
+// NewPassphraseGenerator creates a generator joining count words with separator,
+// e.g. amber-river-seven. words is the list to draw from, nil uses DefaultWordList.
+func NewPassphraseGenerator(words []string, count int, separator string, capitalization Capitalization) (*PassphraseGenerator, error) {
+	if count <= 0 {
+		return nil, errors.New("word count must be greater than 0")
+	}
+	if separator == "" {
+		return nil, errors.New("separator is empty")
+	}
+	if capitalization < LowerCase || capitalization > UpperCase {
+		return nil, fmt.Errorf("invalid capitalization %d", capitalization)
+	}
+	if words == nil {
+		words = DefaultWordList()
+	}
+
+	seen := make(map[string]bool, len(words))
+	list := make([]string, 0, len(words))
+	for _, word := range words {
+		word = strings.ToLower(word)
+		if word == "" || strings.Contains(word, separator) || strings.ContainsAny(word, "-_") || strings.IndexFunc(word, unicode.IsSpace) >= 0 {
+			return nil, fmt.Errorf("invalid word %q in word list", word)
+		}
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+		list = append(list, word)
+	}
+	if len(list) < 2 {
+		return nil, errors.New("word list must have at least 2 different words")
+	}
+
+	return &PassphraseGenerator{words: list, count: count, separator: separator, capitalization: capitalization}, nil
+}
+
+func (p *PassphraseGenerator) Generate() string {
+	code, _ := p.GenerateE()
+	return code
+}
+
+func (p *PassphraseGenerator) GenerateE() (string, error) {
+	result := make([]string, p.count)
+	for i := range result {
+		index, err := secureIntn(len(p.words))
+		if err != nil {
+			return "", err
+		}
+		result[i] = p.words[index]
+	}
+	return p.join(result), nil
+}
+
+// Entropy returns the strength of a generated passphrase in bits.
+func (p *PassphraseGenerator) Entropy() float64 {
+	return float64(p.count) * math.Log2(float64(len(p.words)))
+}
+
+// Normalize accepts spaces, hyphens, underscores or the separator between words
+// interchangeably and ignores case.
+func (p *PassphraseGenerator) Normalize(code string) string {
+	words := strings.FieldsFunc(strings.ReplaceAll(code, p.separator, " "), func(r rune) bool {
+		return unicode.IsSpace(r) || r == '-' || r == '_'
+	})
+	for i, word := range words {
+		words[i] = strings.ToLower(word)
+	}
+	return p.join(words)
+}
+
+func (p *PassphraseGenerator) join(words []string) string {
+	result := make([]string, len(words))
+	for i, word := range words {
+		switch p.capitalization {
+		case TitleCase:
+			runes := []rune(word)
+			runes[0] = unicode.ToUpper(runes[0])
+			result[i] = string(runes)
+		case UpperCase:
+			result[i] = strings.ToUpper(word)
+		default:
+			result[i] = word
+		}
+	}
+	return strings.Join(result, p.separator)
+}
@@ -0,0 +1,98 @@
+package go_verification
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestNewPassphraseGenerator(t *testing.T) {
+	tests := []struct {
+		name      string
+		words     []string
+		count     int
+		separator string
+		wantErr   bool
+	}{
+		{"Test default word list", nil, 3, "-", false},
+		{"Test custom word list", []string{"amber", "river", "seven"}, 3, " ", false},
+		{"Test zero words", nil, 0, "-", true},
+		{"Test empty separator", nil, 3, "", true},
+		{"Test single word list", []string{"amber", "Amber"}, 3, "-", true},
+		{"Test word containing separator", []string{"amber", "t-shirt"}, 3, "-", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPassphraseGenerator(tt.words, tt.count, tt.separator, LowerCase)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, but got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestPassphraseGeneratorGenerate(t *testing.T) {
+	t.Run("Test default configuration", func(t *testing.T) {
+		gen, err := NewPassphraseGenerator(nil, 3, "-", LowerCase)
+		if err != nil {
+			t.Fatalf("NewPassphraseGenerator error: %v", err)
+		}
+		result := gen.Generate()
+
+		words := strings.Split(result, "-")
+		if len(words) != 3 {
+			t.Fatalf("Expected 3 words, but got %s", result)
+		}
+		for _, word := range words {
+			if word == "" || strings.ToLower(word) != word {
+				t.Errorf("Expected lower case words, but got %s", result)
+			}
+		}
+	})
+
+	t.Run("Test title case", func(t *testing.T) {
+		gen, _ := NewPassphraseGenerator([]string{"amber", "river"}, 2, " ", TitleCase)
+		for _, word := range strings.Split(gen.Generate(), " ") {
+			if word != "Amber" && word != "River" {
+				t.Errorf("Expected title case word, but got %s", word)
+			}
+		}
+	})
+}
+
+func TestPassphraseGeneratorEntropy(t *testing.T) {
+	gen, _ := NewPassphraseGenerator(nil, 3, "-", LowerCase)
+	expected := 3 * math.Log2(float64(len(DefaultWordList())))
+
+	if math.Abs(gen.Entropy()-expected) > 1e-9 {
+		t.Errorf("Expected entropy %f, but got %f", expected, gen.Entropy())
+	}
+	if gen.Entropy() < 38 {
+		t.Errorf("Expected about 38.7 bits for 3 default words, but got %f", gen.Entropy())
+	}
+}
+
+func TestPassphraseGeneratorNormalize(t *testing.T) {
+	gen, _ := NewPassphraseGenerator(nil, 3, "-", LowerCase)
+	upper, _ := NewPassphraseGenerator(nil, 3, ".", UpperCase)
+
+	tests := []struct {
+		name      string
+		generator *PassphraseGenerator
+		input     string
+		expected  string
+	}{
+		{"Test spaces", gen, "amber river seven", "amber-river-seven"},
+		{"Test mixed separators and case", gen, " Amber - river_SEVEN ", "amber-river-seven"},
+		{"Test custom separator", upper, "amber-river seven", "AMBER.RIVER.SEVEN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.generator.Normalize(tt.input); result != tt.expected {
+				t.Errorf("Expected %s, but got %s", tt.expected, result)
+			}
+		})
+	}
+}
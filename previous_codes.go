@@ -0,0 +1,92 @@
+package go_verification
+
+import "errors"
+
+// PreviousCodeStore keeps the codes RegenerateCode replaced, so they stay valid when
+// Config.ValidCodes is more than 1. RedisCodeRepository, MemoryCodeRepository and
+// EncryptedCodeRepository implement it.
+type PreviousCodeStore interface {
+	// PushPreviousCode adds code to the previous codes of its username and scope and keeps
+	// the limit last pushed ones.
+	PushPreviousCode(code *VerificationCode, limit int) error
+	// GetPreviousCodes returns the unexpired previous codes of username for scope.
+	GetPreviousCodes(username, scope string) ([]*VerificationCode, error)
+	DeletePreviousCodes(username, scope string) error
+}
+
+// validCodes returns how many codes of scope are valid at once.
+func (v *VerificationCodeHandler) validCodes(scope string) int {
+	if policy, ok := v.config.Scopes[scope]; ok && policy.ValidCodes != 0 {
+		return policy.ValidCodes
+	}
+	return v.config.ValidCodes
+}
+
+// keepPrevious stores the code RegenerateCode is about to replace.
+func (v *VerificationCodeHandler) keepPrevious(code *VerificationCode) error {
+	limit := v.validCodes(code.Scope) - 1
+	if limit < 1 {
+		return nil
+	}
+	return v.repository.(PreviousCodeStore).PushPreviousCode(code, limit)
+}
+
+// previousCode returns the previous code of username for scope that matches code.
+func (v *VerificationCodeHandler) previousCode(username, code, scope string) (*VerificationCode, error) {
+	if v.validCodes(scope) < 2 {
+		return nil, ErrInvalidCode
+	}
+	previous, err := v.repository.(PreviousCodeStore).GetPreviousCodes(username, scope)
+	if err != nil {
+		return nil, err
+	}
+	for _, verify := range previous {
+		if verify.Code == code {
+			return verify, nil
+		}
+	}
+	return nil, ErrInvalidCode
+}
+
+// consume deletes every valid code of username for scope after one of them was used.
+func (v *VerificationCodeHandler) consume(username, scope string) error {
+	if v.validCodes(scope) < 2 {
+		return nil
+	}
+	v.repository.DeleteCode(username, scope)
+	return v.repository.(PreviousCodeStore).DeletePreviousCodes(username, scope)
+}
+
+// forgetPrevious deletes the previous codes of username for scope before a code is
+// generated without an active one, so codes revoked with it can't come back.
+func (v *VerificationCodeHandler) forgetPrevious(username, scope string) error {
+	if v.validCodes(scope) < 2 {
+		return nil
+	}
+	return v.repository.(PreviousCodeStore).DeletePreviousCodes(username, scope)
+}
+
+// maxValidCodes returns the most codes any scope of config accepts at once.
+func maxValidCodes(config *Config) int {
+	most := 1
+	if config.ValidCodes > most {
+		most = config.ValidCodes
+	}
+	for _, policy := range config.Scopes {
+		if policy.ValidCodes > most {
+			most = policy.ValidCodes
+		}
+	}
+	return most
+}
+
+func checkValidCodes(repository CodeRepositoryInterface, config *Config) error {
+	enabled := config.ValidCodes > 1
+	for _, policy := range config.Scopes {
+		enabled = enabled || policy.ValidCodes > 1
+	}
+	if enabled && !supports[PreviousCodeStore](repository) {
+		return errors.New("repository does not support several valid codes")
+	}
+	return nil
+}
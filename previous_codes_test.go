@@ -0,0 +1,118 @@
+package go_verification
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerificationCodeHandler_ValidCodes(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, repository CodeRepositoryInterface) {
+		config := &Config{ValidCodes: 3, Scopes: map[string]ScopePolicy{"single": {ValidCodes: 1}}}
+		handler, err := NewVerificationCodeHandler(&MockCodeGenerator{length: 8}, repository, config)
+		if err != nil {
+			t.Fatalf("NewVerificationCodeHandler error: %v", err)
+		}
+		defer repository.DeleteAllCodes("testuser")
+
+		first, _ := handler.GenerateCode("testuser", "login")
+		second, _ := handler.RegenerateCode("testuser", "login", false)
+		third, _ := handler.RegenerateCode("testuser", "login", true)
+		for _, code := range []string{first.Code, second.Code} {
+			if _, err := handler.GetCode("testuser", "login"); err != nil {
+				t.Fatalf("GetCode error: %v", err)
+			}
+			if valid, err := handler.CheckCode("testuser", code+"x", "login"); valid || !errors.Is(err, ErrInvalidCode) {
+				t.Errorf("Expected ErrInvalidCode, but got %v", err)
+			}
+		}
+
+		fourth, _ := handler.RegenerateCode("testuser", "login", false)
+		if _, err := handler.CheckCode("testuser", first.Code, "login"); !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("Expected the 4th last code to be invalid, got %v", err)
+		}
+		if valid, err := handler.CheckCode("testuser", second.Code, "login"); !valid {
+			t.Errorf("Expected a previous code to be valid, got %v", err)
+		}
+		for _, code := range []string{third.Code, fourth.Code} {
+			if _, err := handler.CheckCode("testuser", code, "login"); !errors.Is(err, ErrCodeNotFound) {
+				t.Errorf("Expected the codes to be consumed, got %v", err)
+			}
+		}
+
+		single, _ := handler.GenerateCode("testuser", "single")
+		_, _ = handler.RegenerateCode("testuser", "single", false)
+		if _, err := handler.CheckCode("testuser", single.Code, "single"); !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("Expected replaced codes of the scope to be invalid, got %v", err)
+		}
+	})
+}
+
+func TestNewVerificationCodeHandler_ValidCodes(t *testing.T) {
+	config := &Config{Scopes: map[string]ScopePolicy{"login": {ValidCodes: 2}}}
+	if _, err := NewVerificationCodeHandler(&MockCodeGenerator{defCode: "123456"}, NewMockCodeRepository(), config); err == nil {
+		t.Error("Expected an error for a repository without PreviousCodeStore")
+	}
+}
+
+func TestVerificationCodeHandler_ValidCodesRevoked(t *testing.T) {
+	deletes := map[string]func(handler *VerificationCodeHandler, repository CodeRepositoryInterface){
+		"DeleteCode": func(handler *VerificationCodeHandler, _ CodeRepositoryInterface) {
+			handler.DeleteCode("testuser", "login")
+		},
+		"DeleteAllCodes": func(_ *VerificationCodeHandler, repository CodeRepositoryInterface) {
+			repository.DeleteAllCodes("testuser")
+		},
+		"DeleteScope": func(_ *VerificationCodeHandler, repository CodeRepositoryInterface) {
+			_, _ = repository.DeleteScope("login")
+		},
+	}
+	forEachRepository(t, func(t *testing.T, repository CodeRepositoryInterface) {
+		handler, err := NewVerificationCodeHandler(&MockCodeGenerator{length: 8}, repository, &Config{ValidCodes: 3})
+		if err != nil {
+			t.Fatalf("NewVerificationCodeHandler error: %v", err)
+		}
+		for name, deleteCodes := range deletes {
+			t.Run(name, func(t *testing.T) {
+				defer repository.DeleteAllCodes("testuser")
+
+				first, _ := handler.GenerateCode("testuser", "login")
+				second, _ := handler.RegenerateCode("testuser", "login", false)
+				deleteCodes(handler, repository)
+				if previous, err := repository.(PreviousCodeStore).GetPreviousCodes("testuser", "login"); err != nil || len(previous) != 0 {
+					t.Errorf("Expected no previous codes, but got %d (%v)", len(previous), err)
+				}
+				if _, err := handler.GenerateCode("testuser", "login"); err != nil {
+					t.Fatalf("GenerateCode error: %v", err)
+				}
+				for _, code := range []string{first.Code, second.Code} {
+					if valid, err := handler.CheckCode("testuser", code, "login"); valid || !errors.Is(err, ErrInvalidCode) {
+						t.Errorf("Expected revoked code to be invalid, but got %v", err)
+					}
+				}
+			})
+		}
+	})
+}
+
+func TestNewVerificationCodeHandler_ValidCodesStrength(t *testing.T) {
+	generator := mustGenerator(NewNumberGenerator(6, false))
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr bool
+	}{
+		{"Test single code", &Config{MaxAttempts: 20, MaxGuessProbability: 0.0001}, false},
+		{"Test valid codes", &Config{MaxAttempts: 20, MaxGuessProbability: 0.0001, ValidCodes: 10}, true},
+		{"Test scope valid codes", &Config{MaxAttempts: 20, MaxGuessProbability: 0.0001,
+			Scopes: map[string]ScopePolicy{"login": {ValidCodes: 10}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewVerificationCodeHandler(generator, NewMemoryCodeRepository(), tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, but got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
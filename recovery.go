@@ -0,0 +1,145 @@
+package go_verification
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// DefaultRecoveryCodeCount is how many codes a recovery set has by default.
+const DefaultRecoveryCodeCount = 10
+
+// minRecoveryEntropy is the weakest generator accepted for codes that never expire.
+const minRecoveryEntropy = 40
+
+// RecoveryCodeStore keeps the hashed recovery codes of usernames. RedisCodeRepository
+// and MemoryCodeRepository implement it, EncryptedCodeRepository forwards it.
+type RecoveryCodeStore interface {
+	// SaveRecoveryCodes replaces the set of username at once.
+	SaveRecoveryCodes(username string, hashes []string) error
+	// BurnRecoveryCode removes hash from the set of username, false when it was not in it.
+	BurnRecoveryCode(username, hash string) (bool, error)
+	CountRecoveryCodes(username string) (int, error)
+	DeleteRecoveryCodes(username string) error
+}
+
+type RecoveryOptions struct {
+	// Count is how many codes a set has, DefaultRecoveryCodeCount by default.
+	Count int
+	// HashKey makes the stored hashes HMAC-SHA256 ones, so a leaked store can't be used to
+	// guess codes offline. Without it codes are hashed with SHA-256.
+	HashKey []byte
+}
+
+// RecoveryCodes mints sets of single-use backup codes for users who lost access to
+// their usual channel. Only hashes of the codes are stored.
+type RecoveryCodes struct {
+	generator CodeGenerator
+	store     RecoveryCodeStore
+	count     int
+	hashKey   []byte
+}
+
+// NewRecoveryCodes returns recovery codes made by generator. Recovery codes don't expire,
+// so generators must report at least 40 bits of entropy drawn from crypto/rand, like the
+// generators of this package.
+func NewRecoveryCodes(generator CodeGenerator, store RecoveryCodeStore, options RecoveryOptions) (*RecoveryCodes, error) {
+	reporter, ok := generator.(EntropyReporter)
+	if !ok {
+		return nil, errors.New("generator does not report its entropy")
+	}
+	if reporter.Entropy() < minRecoveryEntropy {
+		return nil, fmt.Errorf("generator is too weak for recovery codes: %.1f bits of entropy, min is %d", reporter.Entropy(), minRecoveryEntropy)
+	}
+	if !supports[RecoveryCodeStore](store) {
+		return nil, errors.New("repository does not support recovery codes")
+	}
+	if options.Count < 0 {
+		return nil, errors.New("count must not be negative")
+	}
+	if options.Count == 0 {
+		options.Count = DefaultRecoveryCodeCount
+	}
+	return &RecoveryCodes{generator: generator, store: store, count: options.Count, hashKey: options.HashKey}, nil
+}
+
+// Generate replaces the recovery codes of username with a new set and returns it. The
+// codes can't be read again, show them to the user once.
+func (r *RecoveryCodes) Generate(username string) ([]string, error) {
+	codes := make([]string, 0, r.count)
+	hashes := make([]string, 0, r.count)
+	seen := make(map[string]bool, r.count)
+	for attempts := 0; len(codes) < r.count; attempts++ {
+		if attempts >= r.count*10 {
+			return nil, errors.New("generator returned too many duplicate codes")
+		}
+		code, err := r.generate()
+		if err != nil {
+			return nil, err
+		}
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+		codes = append(codes, code)
+		hashes = append(hashes, r.hash(username, r.normalize(code)))
+	}
+	if err := r.store.SaveRecoveryCodes(username, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify burns code and returns how many codes of username remain. A code that is not in
+// the set, or was already used, fails with ErrInvalidCode.
+func (r *RecoveryCodes) Verify(username, code string) (int, error) {
+	burned, err := r.store.BurnRecoveryCode(username, r.hash(username, r.normalize(code)))
+	if err != nil {
+		return 0, err
+	}
+	if !burned {
+		return 0, ErrInvalidCode
+	}
+	return r.store.CountRecoveryCodes(username)
+}
+
+// Remaining returns how many unused codes username has.
+func (r *RecoveryCodes) Remaining(username string) (int, error) {
+	return r.store.CountRecoveryCodes(username)
+}
+
+func (r *RecoveryCodes) Delete(username string) error {
+	return r.store.DeleteRecoveryCodes(username)
+}
+
+func (r *RecoveryCodes) generate() (string, error) {
+	if generator, ok := r.generator.(FallibleGenerator); ok {
+		return generator.GenerateE()
+	}
+	code := r.generator.Generate()
+	if code == "" {
+		return "", errors.New("generator returned an empty code")
+	}
+	return code, nil
+}
+
+func (r *RecoveryCodes) normalize(code string) string {
+	if normalizer, ok := r.generator.(CodeNormalizer); ok {
+		return normalizer.Normalize(code)
+	}
+	return code
+}
+
+// hash binds the code to username, so a hash can't be copied to another user.
+func (r *RecoveryCodes) hash(username, code string) string {
+	data := []byte(username + "\x00" + code)
+	if r.hashKey == nil {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, r.hashKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
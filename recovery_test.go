@@ -0,0 +1,78 @@
+package go_verification
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// duplicateGenerator claims a large keyspace but always returns the same code.
+type duplicateGenerator struct{}
+
+func (duplicateGenerator) Generate() string {
+	return "ABCDE-FGHJK"
+}
+
+func (duplicateGenerator) Entropy() float64 {
+	return 50
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	generator, _ := NewCharsetGenerator(NoAmbiguousCharset, 0, "XXXXX-XXXXX")
+	forEachRepository(t, func(t *testing.T, repository CodeRepositoryInterface) {
+		store := repository.(RecoveryCodeStore)
+		recovery, err := NewRecoveryCodes(generator, store, RecoveryOptions{Count: 5, HashKey: []byte("0123456789abcdef")})
+		if err != nil {
+			t.Fatalf("NewRecoveryCodes error: %v", err)
+		}
+		defer recovery.Delete("testuser")
+
+		codes, err := recovery.Generate("testuser")
+		if err != nil || len(codes) != 5 {
+			t.Fatalf("Expected 5 codes, got %v, %v", codes, err)
+		}
+		remaining, err := recovery.Verify("testuser", strings.ToLower(codes[0]))
+		if err != nil || remaining != 4 {
+			t.Errorf("Expected 4 remaining codes, got %d, %v", remaining, err)
+		}
+		if _, err := recovery.Verify("testuser", codes[0]); !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("Expected a used code to fail with ErrInvalidCode, got %v", err)
+		}
+		if _, err := recovery.Verify("otheruser", codes[1]); !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("Expected the code of another user to fail with ErrInvalidCode, got %v", err)
+		}
+
+		regenerated, _ := recovery.Generate("testuser")
+		if _, err := recovery.Verify("testuser", codes[1]); !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("Expected codes of the old set to fail, got %v", err)
+		}
+		if remaining, _ := recovery.Remaining("testuser"); remaining != 5 {
+			t.Errorf("Expected 5 remaining codes, got %d", remaining)
+		}
+		if _, err := recovery.Verify("testuser", regenerated[4]); err != nil {
+			t.Errorf("Expected a code of the new set to be valid, got %v", err)
+		}
+
+		_ = recovery.Delete("testuser")
+		if remaining, _ := recovery.Remaining("testuser"); remaining != 0 {
+			t.Errorf("Expected no remaining codes, got %d", remaining)
+		}
+	})
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	generator, _ := NewNumberGenerator(6, false)
+	if _, err := NewRecoveryCodes(generator, NewMemoryCodeRepository(), RecoveryOptions{}); err == nil {
+		t.Error("Expected weak generators to be refused")
+	}
+	if _, err := NewRecoveryCodes(&MockCodeGenerator{defCode: "123456"}, NewMemoryCodeRepository(), RecoveryOptions{Count: 2}); err == nil {
+		t.Error("Expected generators without entropy to be refused")
+	}
+	recovery, err := NewRecoveryCodes(&duplicateGenerator{}, NewMemoryCodeRepository(), RecoveryOptions{Count: 2})
+	if err != nil {
+		t.Fatalf("NewRecoveryCodes error: %v", err)
+	}
+	if _, err := recovery.Generate("testuser"); err == nil {
+		t.Error("Expected an error when the generator only returns duplicates")
+	}
+}
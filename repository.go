@@ -6,6 +6,7 @@ import (
 	"errors"
 	"github.com/redis/go-redis/v9"
 	"log"
+	"strconv"
 	"time"
 )
 
@@ -14,72 +15,164 @@ type CodeRepositoryInterface interface {
 	GetCode(username, scope string) (*VerificationCode, error)
 	DeleteCode(username, scope string) bool
 	DeleteAllCodes(username string) bool
+	// DeleteScope deletes the codes of every username for scope and returns how many were deleted.
+	DeleteScope(scope string) (int, error)
+}
+
+// RepositoryWrapper is implemented by repositories wrapping another one, like
+// EncryptedCodeRepository. They have the methods of every optional store, so the stores
+// a configuration needs are looked up in the wrapped repository too.
+type RepositoryWrapper interface {
+	Unwrap() CodeRepositoryInterface
+}
+
+// supports reports whether repository implements T, and so do the repositories it wraps.
+func supports[T any](repository interface{}) bool {
+	if _, ok := repository.(T); !ok {
+		return false
+	}
+	if wrapper, ok := repository.(RepositoryWrapper); ok {
+		return supports[T](wrapper.Unwrap())
+	}
+	return true
 }
 
 type RedisConfig struct {
-	Password string
-	Prefix   string
-	Addr     string
-	DB       int
+	Password string `json:"password" yaml:"password"`
+	Prefix   string `json:"prefix" yaml:"prefix"`
+	Addr     string `json:"addr" yaml:"addr"`
+	DB       int    `json:"db" yaml:"db"`
+	// KeyBuilder defaults to PlainKeyBuilder.
+	KeyBuilder KeyBuilder `json:"-" yaml:"-"`
+	// Codec defaults to JSONCodec.
+	Codec Codec `json:"-" yaml:"-"`
 }
 
 type RedisCodeRepository struct {
 	client *redis.Client
 	prefix string
+	keys   KeyBuilder
+	codec  Codec
 	ctx    context.Context
 }
 
+// NewRedisCodeRepository connects to Redis and exits the program when it can't, use
+// ConnectRedisCodeRepository to handle the error.
 func NewRedisCodeRepository(ctx context.Context, options RedisConfig) *RedisCodeRepository {
+	repository, err := ConnectRedisCodeRepository(ctx, options)
+	if err != nil {
+		log.Fatalf("Cannot ping redis %s", err)
+	}
+	return repository
+}
+
+// ConnectRedisCodeRepository connects to Redis and returns an error when it can't ping it.
+func ConnectRedisCodeRepository(ctx context.Context, options RedisConfig) (*RedisCodeRepository, error) {
 	client := redis.NewClient(&redis.Options{
 		Addr:     options.Addr,
 		Password: options.Password,
 		DB:       options.DB,
 	})
-	err := client.Ping(ctx).Err()
-	if err != nil {
-		log.Fatalf("Cannot ping redis %s", err)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
 	}
 
-	return &RedisCodeRepository{client: client, prefix: options.Prefix, ctx: ctx}
+	keys := options.KeyBuilder
+	if keys == nil {
+		keys = PlainKeyBuilder{}
+	}
+	codec := options.Codec
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+	return &RedisCodeRepository{client: client, prefix: options.Prefix, keys: keys, codec: codec, ctx: ctx}, nil
 }
 
-func (r RedisCodeRepository) SaveCode(username, code, scope string, expiresTime time.Duration) (*VerificationCode, error) {
-	verification := &VerificationCode{
-		ExpiredAt:   time.Now().Add(expiresTime),
-		ExpiredTime: Duration(expiresTime),
-		ExpireAfter: int(expiresTime.Seconds()),
-		Username:    username,
-		Scope:       scope,
-		Code:        code,
+// WithTenant keeps the keys of tenant under prefix:{tenant}, the braces make them a
+// Redis Cluster hash tag.
+func (r RedisCodeRepository) WithTenant(tenant string) (CodeRepositoryInterface, error) {
+	if err := checkTenant(tenant); err != nil {
+		return nil, err
 	}
+	r.prefix = r.prefix + ":{" + tenant + "}"
+	return &r, nil
+}
+
+func (r RedisCodeRepository) SaveCode(username, code, scope string, expiresTime time.Duration) (*VerificationCode, error) {
+	return r.SaveCodeWithMetadata(username, code, scope, expiresTime, nil)
+}
 
-	data, _ := json.Marshal(&verification)
+func (r RedisCodeRepository) SaveCodeWithMetadata(username, code, scope string, expiresTime time.Duration, metadata map[string]string) (*VerificationCode, error) {
+	if err := r.checkKey(username, scope); err != nil {
+		return nil, err
+	}
+	verification := newVerificationCode(username, code, scope, expiresTime, metadata)
+	data, err := r.marshal(r.createKeyScope(username, scope), verification)
+	if err != nil {
+		return nil, err
+	}
 	if res := r.client.Set(r.ctx, r.createKeyScope(username, scope), data, expiresTime); res.Err() != nil {
 		return nil, res.Err()
 	}
 	return verification, nil
 }
 
+// SaveCodeIfAbsent saves the code with SET NX and reads the code that won otherwise.
+func (r RedisCodeRepository) SaveCodeIfAbsent(username, code, scope string, expiresTime time.Duration, metadata map[string]string) (*VerificationCode, error) {
+	if err := r.checkKey(username, scope); err != nil {
+		return nil, err
+	}
+	verification := newVerificationCode(username, code, scope, expiresTime, metadata)
+	data, err := r.marshal(r.createKeyScope(username, scope), verification)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; attempt < 3; attempt++ {
+		saved, err := r.client.SetNX(r.ctx, r.createKeyScope(username, scope), data, expiresTime).Result()
+		if err != nil {
+			return nil, err
+		}
+		if saved {
+			return verification, nil
+		}
+		// the winner can expire or be deleted before it is read, then try again
+		winner, err := r.GetCode(username, scope)
+		if !errors.Is(err, ErrCodeNotFound) {
+			return winner, err
+		}
+	}
+	return nil, errors.New("code changed too often")
+}
+
 func (r RedisCodeRepository) GetCode(username, scope string) (*VerificationCode, error) {
 	res, err := r.client.Get(r.ctx, r.createKeyScope(username, scope)).Result()
 	if err == redis.Nil {
 		//fmt.Println("key2 does not exist")
-		return nil, errors.New("does not exist")
+		return nil, ErrCodeNotFound
 	} else if err != nil {
 		return nil, err
 	} else {
-		var data VerificationCode
-		err := json.Unmarshal([]byte(res), &data)
+		data, err := r.unmarshal(r.createKeyScope(username, scope), []byte(res))
 		if err != nil {
 			return nil, err
 		}
+		if data.Username != username || data.Scope != scope {
+			// another record under the same key, e.g. moved there or a ':' collision
+			return nil, ErrCodeNotFound
+		}
 		data.ExpireAfter = int(data.ExpiredAt.Sub(time.Now()).Seconds())
-		return &data, nil
+		return data, nil
 	}
 }
 
 func (r RedisCodeRepository) DeleteCode(username, scope string) bool {
-	if err := r.client.Del(r.ctx, r.createKeyScope(username, scope)).Err(); err != nil {
+	if r.checkKey(username, scope) != nil {
+		// the key may hold the code of another username or scope
+		return false
+	}
+	// previous codes go with the active one, or a fresh code would revive them
+	if err := r.client.Del(r.ctx, r.createKeyScope(username, scope), r.createPreviousKey(username, scope)).Err(); err != nil {
 		//log error
 		return false
 	}
@@ -87,6 +180,10 @@ func (r RedisCodeRepository) DeleteCode(username, scope string) bool {
 }
 
 func (r RedisCodeRepository) DeleteAllCodes(username string) bool {
+	if username == "" {
+		// an empty username would make the pattern match every code
+		return false
+	}
 	var cursor uint64
 	for {
 		keys, nextCursor, err := r.client.Scan(r.ctx, cursor, r.createKey(username), 50).Result()
@@ -94,6 +191,13 @@ func (r RedisCodeRepository) DeleteAllCodes(username string) bool {
 			log.Printf("Error during scan keys : %s", err)
 			return false
 		}
+		// the pattern also matches the keys of tenants, other records and other usernames
+		keys, previous, err := r.codeKeys(keys, ListOptions{Username: username})
+		if err != nil {
+			log.Printf("Error during scan keys : %s", err)
+			return false
+		}
+		keys = append(keys, previous...)
 
 		// Delete keys
 		if len(keys) > 0 {
@@ -115,10 +219,531 @@ func (r RedisCodeRepository) DeleteAllCodes(username string) bool {
 	return true
 }
 
+// DeleteScope scans the codes of scope and unlinks them in batches. Keys under the
+// prefix that don't hold a code of scope, like grants, are skipped.
+func (r RedisCodeRepository) DeleteScope(scope string) (int, error) {
+	if scope == "" {
+		return 0, errors.New("scope is required")
+	}
+	deleted := 0
+	var cursor uint64
+	for {
+		keys, nextCursor, err := r.client.Scan(r.ctx, cursor, r.keys.Pattern(r.prefix, scope, ""), 500).Result()
+		if err != nil {
+			return deleted, err
+		}
+		keys, previous, err := r.codeKeys(keys, ListOptions{Scope: scope})
+		if err != nil {
+			return deleted, err
+		}
+		if len(keys) > 0 {
+			count, err := r.client.Unlink(r.ctx, keys...).Result()
+			if err != nil {
+				return deleted, err
+			}
+			deleted += int(count)
+		}
+		if len(previous) > 0 {
+			if err := r.client.Unlink(r.ctx, previous...).Err(); err != nil {
+				return deleted, err
+			}
+		}
+
+		cursor = nextCursor
+		if cursor == 0 {
+			break
+		}
+	}
+	return deleted, nil
+}
+
+// ListCodes scans the keys of the repository, the cursor is the one of SCAN.
+func (r RedisCodeRepository) ListCodes(options ListOptions) (*CodePage, error) {
+	var cursor uint64
+	if options.Cursor != "" {
+		var err error
+		if cursor, err = strconv.ParseUint(options.Cursor, 10, 64); err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+	}
+	page := &CodePage{}
+	limit := options.limit()
+	for {
+		keys, nextCursor, err := r.client.Scan(r.ctx, cursor, r.keys.Pattern(r.prefix, options.Scope, options.Username), int64(limit)).Result()
+		if err != nil {
+			return nil, err
+		}
+		codes, err := r.getCodes(keys)
+		if err != nil {
+			return nil, err
+		}
+		for _, code := range codes {
+			if options.matches(code.Username, code.Scope) {
+				page.Codes = append(page.Codes, options.redact(code))
+			}
+		}
+
+		cursor = nextCursor
+		if cursor == 0 {
+			break
+		}
+		if len(page.Codes) >= limit {
+			page.NextCursor = strconv.FormatUint(cursor, 10)
+			break
+		}
+	}
+	return page, nil
+}
+
+// getCodes reads the codes of keys, skipping expired ones and values that are not codes.
+func (r RedisCodeRepository) getCodes(keys []string) ([]*VerificationCode, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	values, err := r.client.MGet(r.ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	var codes []*VerificationCode
+	for i, value := range values {
+		res, ok := value.(string)
+		if !ok {
+			// expired between SCAN and MGET
+			continue
+		}
+		data, err := r.unmarshal(keys[i], []byte(res))
+		if err != nil || keys[i] != r.createKeyScope(data.Username, data.Scope) {
+			// not a code, or sealed for another key
+			continue
+		}
+		data.ExpireAfter = int(time.Until(data.ExpiredAt).Seconds())
+		codes = append(codes, data)
+	}
+	return codes, nil
+}
+
+// codeKeys returns the keys holding a code of options under its own key, so patterns
+// matching grants, recovery codes or other usernames through ':' don't delete them,
+// along with the keys of the previous codes of those codes.
+func (r RedisCodeRepository) codeKeys(keys []string, options ListOptions) ([]string, []string, error) {
+	if len(keys) == 0 {
+		return nil, nil, nil
+	}
+	values, err := r.client.MGet(r.ctx, keys...).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	var codeKeys, previousKeys []string
+	for i, value := range values {
+		res, ok := value.(string)
+		if !ok {
+			// expired, or not a string like the sets of recovery codes
+			continue
+		}
+		data, err := r.unmarshal(keys[i], []byte(res))
+		if err != nil || keys[i] != r.createKeyScope(data.Username, data.Scope) || !options.matches(data.Username, data.Scope) {
+			continue
+		}
+		codeKeys = append(codeKeys, keys[i])
+		previousKeys = append(previousKeys, r.createPreviousKey(data.Username, data.Scope))
+	}
+	return codeKeys, previousKeys, nil
+}
+
+func newVerificationCode(username, code, scope string, expiresTime time.Duration, metadata map[string]string) *VerificationCode {
+	return &VerificationCode{
+		ExpiredAt:   time.Now().Add(expiresTime),
+		ExpiredTime: Duration(expiresTime),
+		ExpireAfter: int(expiresTime.Seconds()),
+		Username:    username,
+		Scope:       scope,
+		Code:        code,
+		Metadata:    copyMetadata(metadata),
+	}
+}
+
+// checkKey asks the KeyBuilder, when it is a KeyValidator, whether username and scope
+// have a key of their own.
+func (r RedisCodeRepository) checkKey(username, scope string) error {
+	if validator, ok := r.keys.(KeyValidator); ok {
+		return validator.Validate(scope, username)
+	}
+	return nil
+}
+
+// marshal encodes code for key, a KeyedCodec binds the record to it.
+func (r RedisCodeRepository) marshal(key string, code *VerificationCode) ([]byte, error) {
+	if codec, ok := r.codec.(KeyedCodec); ok {
+		return codec.MarshalKey(key, code)
+	}
+	return r.codec.Marshal(code)
+}
+
+func (r RedisCodeRepository) unmarshal(key string, data []byte) (*VerificationCode, error) {
+	if codec, ok := r.codec.(KeyedCodec); ok {
+		return codec.UnmarshalKey(key, data)
+	}
+	return r.codec.Unmarshal(data)
+}
+
 func (r RedisCodeRepository) createKeyScope(username string, scope string) string {
-	return r.prefix + ":" + scope + ":" + username
+	return r.keys.Key(r.prefix, scope, username)
 }
 
 func (r RedisCodeRepository) createKey(username string) string {
-	return r.prefix + ":*:" + username
+	return r.keys.Pattern(r.prefix, "", username)
+}
+
+func (r RedisCodeRepository) SaveGrant(id string, grant *Grant, ttl time.Duration) error {
+	data, err := json.Marshal(grant)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(r.ctx, r.createGrantKey(id), data, ttl).Err()
+}
+
+func (r RedisCodeRepository) GetGrant(id string) (*Grant, error) {
+	return r.decodeGrant(r.client.Get(r.ctx, r.createGrantKey(id)).Result())
+}
+
+func (r RedisCodeRepository) TakeGrant(id string) (*Grant, error) {
+	return r.decodeGrant(r.client.GetDel(r.ctx, r.createGrantKey(id)).Result())
+}
+
+func (r RedisCodeRepository) decodeGrant(res string, err error) (*Grant, error) {
+	if err == redis.Nil {
+		return nil, ErrInvalidGrant
+	} else if err != nil {
+		return nil, err
+	}
+	var grant Grant
+	if err := json.Unmarshal([]byte(res), &grant); err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+func (r RedisCodeRepository) createGrantKey(id string) string {
+	return r.prefix + ":grant:" + grantKey(id)
+}
+
+func (r RedisCodeRepository) SaveRecoveryCodes(username string, hashes []string) error {
+	key := r.createRecoveryKey(username)
+	members := make([]interface{}, len(hashes))
+	for i, hash := range hashes {
+		members[i] = hash
+	}
+	_, err := r.client.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(r.ctx, key)
+		if len(members) > 0 {
+			pipe.SAdd(r.ctx, key, members...)
+		}
+		return nil
+	})
+	return err
+}
+
+func (r RedisCodeRepository) BurnRecoveryCode(username, hash string) (bool, error) {
+	removed, err := r.client.SRem(r.ctx, r.createRecoveryKey(username), hash).Result()
+	return removed == 1, err
+}
+
+func (r RedisCodeRepository) CountRecoveryCodes(username string) (int, error) {
+	count, err := r.client.SCard(r.ctx, r.createRecoveryKey(username)).Result()
+	return int(count), err
+}
+
+func (r RedisCodeRepository) DeleteRecoveryCodes(username string) error {
+	return r.client.Del(r.ctx, r.createRecoveryKey(username)).Err()
+}
+
+// createRecoveryKey hashes the username like grant keys, so code patterns never match it.
+func (r RedisCodeRepository) createRecoveryKey(username string) string {
+	return r.prefix + ":recovery:" + grantKey(username)
+}
+
+// PushPreviousCode keeps previous codes in a sorted set scored by when they were
+// replaced. The set expires with its last code.
+func (r RedisCodeRepository) PushPreviousCode(code *VerificationCode, limit int) error {
+	key := r.createPreviousKey(code.Username, code.Scope)
+	data, err := r.marshal(key, code)
+	if err != nil {
+		return err
+	}
+
+	ttl, err := r.client.PTTL(r.ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	expiresTime := time.Until(code.ExpiredAt)
+	if ttl > expiresTime {
+		expiresTime = ttl
+	}
+	_, err = r.client.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(r.ctx, key, redis.Z{Score: float64(time.Now().UnixNano()), Member: data})
+		pipe.ZRemRangeByRank(r.ctx, key, 0, int64(-limit-1))
+		pipe.PExpire(r.ctx, key, expiresTime)
+		return nil
+	})
+	return err
+}
+
+func (r RedisCodeRepository) GetPreviousCodes(username, scope string) ([]*VerificationCode, error) {
+	key := r.createPreviousKey(username, scope)
+	values, err := r.client.ZRange(r.ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	codes := make([]*VerificationCode, 0, len(values))
+	for _, value := range values {
+		data, err := r.unmarshal(key, []byte(value))
+		if err != nil {
+			return nil, err
+		}
+		if data.Username != username || data.Scope != scope || !data.ExpiredAt.After(time.Now()) {
+			continue
+		}
+		data.ExpireAfter = int(time.Until(data.ExpiredAt).Seconds())
+		codes = append(codes, data)
+	}
+	return codes, nil
+}
+
+func (r RedisCodeRepository) DeletePreviousCodes(username, scope string) error {
+	return r.client.Del(r.ctx, r.createPreviousKey(username, scope)).Err()
+}
+
+func (r RedisCodeRepository) createPreviousKey(username, scope string) string {
+	return r.prefix + ":previous:" + grantKey(scope+"\x00"+username)
+}
+
+// TakeResend updates the resend state in a WATCH transaction, retrying when another
+// send changed it meanwhile.
+func (r RedisCodeRepository) TakeResend(username, scope string, backoff ResendBackoff) (time.Time, error) {
+	key := r.createResendKey(username, scope)
+	var next time.Time
+	take := func(tx *redis.Tx) error {
+		var state resendState
+		data, err := tx.Get(r.ctx, key).Bytes()
+		if err == nil {
+			if err := json.Unmarshal(data, &state); err != nil {
+				return err
+			}
+		} else if err != redis.Nil {
+			return err
+		}
+		state, err = backoff.take(state, time.Now())
+		if err != nil {
+			return err
+		}
+		if data, err = json.Marshal(state); err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(r.ctx, key, data, backoff.quietPeriod())
+			return nil
+		})
+		next = state.NextAt
+		return err
+	}
+	for attempt := 0; attempt < 5; attempt++ {
+		err := r.client.Watch(r.ctx, take, key)
+		if err != redis.TxFailedErr {
+			return next, err
+		}
+	}
+	return time.Time{}, errors.New("resend state changed too often")
+}
+
+// ReleaseResend restores the resend state in a WATCH transaction, it keeps its TTL.
+func (r RedisCodeRepository) ReleaseResend(username, scope string, next time.Time) error {
+	key := r.createResendKey(username, scope)
+	release := func(tx *redis.Tx) error {
+		data, err := tx.Get(r.ctx, key).Bytes()
+		if err == redis.Nil {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var state resendState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return err
+		}
+		state, ok := state.release(next)
+		if !ok {
+			return nil
+		}
+		if data, err = json.Marshal(state); err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(r.ctx, key, data, redis.KeepTTL)
+			return nil
+		})
+		return err
+	}
+	err := r.client.Watch(r.ctx, release, key)
+	if err == redis.TxFailedErr {
+		// another send happened meanwhile, it keeps the slot
+		return nil
+	}
+	return err
+}
+
+func (r RedisCodeRepository) ResetResend(username, scope string) error {
+	return r.client.Del(r.ctx, r.createResendKey(username, scope)).Err()
+}
+
+func (r RedisCodeRepository) createResendKey(username, scope string) string {
+	return r.prefix + ":resend:" + grantKey(scope+"\x00"+username)
+}
+
+// ReserveEvent trims the events of key older than window from its sorted set, adds the
+// event and counts the events in one MULTI.
+func (r RedisCodeRepository) ReserveEvent(key string, window time.Duration) (string, int, time.Time, error) {
+	event, err := newEventID()
+	if err != nil {
+		return "", 0, time.Time{}, err
+	}
+	key = r.createVelocityKey(key)
+	now := time.Now()
+	var count *redis.IntCmd
+	var oldest *redis.ZSliceCmd
+	_, err = r.client.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(r.ctx, key, "-inf", strconv.FormatInt(now.Add(-window).UnixNano(), 10))
+		pipe.ZAdd(r.ctx, key, redis.Z{Score: float64(now.UnixNano()), Member: event})
+		count = pipe.ZCard(r.ctx, key)
+		oldest = pipe.ZRangeWithScores(r.ctx, key, 0, 0)
+		pipe.PExpire(r.ctx, key, window)
+		return nil
+	})
+	if err != nil {
+		return "", 0, time.Time{}, err
+	}
+	var oldestAt time.Time
+	if events := oldest.Val(); len(events) > 0 {
+		oldestAt = time.Unix(0, int64(events[0].Score))
+	}
+	return event, int(count.Val()), oldestAt, nil
+}
+
+func (r RedisCodeRepository) ReleaseEvent(key, event string) error {
+	return r.client.ZRem(r.ctx, r.createVelocityKey(key), event).Err()
+}
+
+// createVelocityKey hashes key, it holds IPs and phone numbers.
+func (r RedisCodeRepository) createVelocityKey(key string) string {
+	return r.prefix + ":velocity:" + grantKey(key)
+}
+
+// errIdempotentResultExists stops SaveIdempotentResult when another call saved a result.
+var errIdempotentResultExists = errors.New("idempotent result exists")
+
+// idempotentRecord is a result of GenerateCode, NextResendAt is not kept by codecs.
+type idempotentRecord struct {
+	Record       []byte
+	NextResendAt time.Time
+}
+
+// idempotencyPending is the value of a reserved key. It is a JSON object like records,
+// so ListCodes takes it for a code of no username and skips it.
+const idempotencyPending = `{"Pending":true}`
+
+func (r RedisCodeRepository) ReserveIdempotencyKey(key string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(r.ctx, r.createIdempotencyKey(key), idempotencyPending, ttl).Result()
+}
+
+// ReleaseIdempotencyKey deletes key in a WATCH transaction, unless a result was saved
+// meanwhile.
+func (r RedisCodeRepository) ReleaseIdempotencyKey(key string) error {
+	redisKey := r.createIdempotencyKey(key)
+	err := r.client.Watch(r.ctx, func(tx *redis.Tx) error {
+		value, err := tx.Get(r.ctx, redisKey).Result()
+		if err == redis.Nil || err == nil && value != idempotencyPending {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(r.ctx, redisKey)
+			return nil
+		})
+		return err
+	}, redisKey)
+	if err == redis.TxFailedErr {
+		// a result was saved meanwhile
+		return nil
+	}
+	return err
+}
+
+// SaveIdempotentResult replaces the reservation of key in a WATCH transaction, unless
+// key has a result.
+func (r RedisCodeRepository) SaveIdempotentResult(key string, code *VerificationCode, ttl time.Duration) (*VerificationCode, error) {
+	redisKey := r.createIdempotencyKey(key)
+	record, err := r.marshal(redisKey, code)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(idempotentRecord{Record: record, NextResendAt: code.NextResendAt})
+	if err != nil {
+		return nil, err
+	}
+	save := func(tx *redis.Tx) error {
+		value, err := tx.Get(r.ctx, redisKey).Result()
+		if err == nil && value != idempotencyPending {
+			return errIdempotentResultExists
+		} else if err != nil && err != redis.Nil {
+			return err
+		}
+		_, err = tx.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(r.ctx, redisKey, data, ttl)
+			return nil
+		})
+		return err
+	}
+	for attempt := 0; attempt < 5; attempt++ {
+		err := r.client.Watch(r.ctx, save, redisKey)
+		if err == nil {
+			return code, nil
+		}
+		if err == errIdempotentResultExists {
+			break
+		}
+		if err != redis.TxFailedErr {
+			return nil, err
+		}
+	}
+	result, err := r.GetIdempotentResult(key)
+	if errors.Is(err, ErrCodeNotFound) {
+		// the result that won expired meanwhile
+		return code, nil
+	}
+	return result, err
+}
+
+func (r RedisCodeRepository) GetIdempotentResult(key string) (*VerificationCode, error) {
+	data, err := r.client.Get(r.ctx, r.createIdempotencyKey(key)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrCodeNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if string(data) == idempotencyPending {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	var record idempotentRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	code, err := r.unmarshal(r.createIdempotencyKey(key), record.Record)
+	if err != nil {
+		return nil, err
+	}
+	code.NextResendAt = record.NextResendAt
+	return code, nil
+}
+
+func (r RedisCodeRepository) createIdempotencyKey(key string) string {
+	return r.prefix + ":idempotency:" + grantKey(key)
 }
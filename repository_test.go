@@ -2,21 +2,60 @@ package go_verification
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
 
-func TestRedisCodeRepository(t *testing.T) {
-	// Replace these values with your actual Redis configuration
-	redisConfig := RedisConfig{
-		Addr:     "localhost:6379",
-		Password: "",
-		DB:       0,
-		Prefix:   "test",
+// newTestRedisRepository connects to the local Redis under a prefix of the test and
+// deletes its keys afterwards. The test is skipped when Redis is not reachable.
+func newTestRedisRepository(t *testing.T) *RedisCodeRepository {
+	t.Helper()
+	return newTestRedisRepositoryWithConfig(t, RedisConfig{})
+}
+
+// newTestRedisRepositoryWithConfig is newTestRedisRepository with the codec, key builder
+// or prefix of config, an empty prefix is unique to the test.
+func newTestRedisRepositoryWithConfig(t *testing.T, config RedisConfig) *RedisCodeRepository {
+	t.Helper()
+	config.Addr = "localhost:6379"
+	if config.Prefix == "" {
+		config.Prefix = fmt.Sprintf("test_%s_%d", strings.ReplaceAll(t.Name(), "/", "_"), time.Now().UnixNano())
 	}
+	repo, err := ConnectRedisCodeRepository(context.TODO(), config)
+	if err != nil {
+		t.Skipf("redis is not reachable: %v", err)
+	}
+	t.Cleanup(func() {
+		if keys, err := repo.client.Keys(repo.ctx, escapeGlob(config.Prefix)+":*").Result(); err == nil && len(keys) > 0 {
+			repo.client.Del(repo.ctx, keys...)
+		}
+		_ = repo.client.Close()
+	})
+	return repo
+}
 
-	ctx := context.TODO()
-	repo := NewRedisCodeRepository(ctx, redisConfig)
+// forEachRepository runs test against a memory, a Redis and an encrypted repository.
+func forEachRepository(t *testing.T, test func(t *testing.T, repository CodeRepositoryInterface)) {
+	keys, _ := NewEncryptionKeys("1", map[string][]byte{"1": make([]byte, 32)})
+	repositories := map[string]func(t *testing.T) CodeRepositoryInterface{
+		"memory": func(t *testing.T) CodeRepositoryInterface { return NewMemoryCodeRepository() },
+		"redis":  func(t *testing.T) CodeRepositoryInterface { return newTestRedisRepository(t) },
+		"encrypted": func(t *testing.T) CodeRepositoryInterface {
+			return NewEncryptedCodeRepository(NewMemoryCodeRepository(), keys)
+		},
+	}
+	for name, newRepository := range repositories {
+		t.Run(name, func(t *testing.T) {
+			test(t, newRepository(t))
+		})
+	}
+}
+
+func TestRedisCodeRepository(t *testing.T) {
+	repo := newTestRedisRepository(t)
 
 	username := "testuser"
 	code := "123456"
@@ -54,16 +93,7 @@ func TestRedisCodeRepository(t *testing.T) {
 }
 
 func TestRedisCodeRepository_DeleteAllCodes(t *testing.T) {
-	// Replace these values with your actual Redis configuration
-	redisConfig := RedisConfig{
-		Addr:     "localhost:6379",
-		Password: "",
-		DB:       0,
-		Prefix:   "test",
-	}
-
-	ctx := context.TODO()
-	repo := NewRedisCodeRepository(ctx, redisConfig)
+	repo := newTestRedisRepository(t)
 
 	username := "testuser"
 	scope1 := "test_scope1"
@@ -96,3 +126,277 @@ func TestRedisCodeRepository_DeleteAllCodes(t *testing.T) {
 		t.Error("GetCode expected to return an error after deletion")
 	}
 }
+
+func TestRedisCodeRepository_Grants(t *testing.T) {
+	repo := newTestRedisRepository(t)
+	grant := &Grant{Username: "testuser", Scope: "test_scope", ExpiredAt: time.Now().Add(time.Minute)}
+
+	if err := repo.SaveGrant("grant-id", grant, time.Minute); err != nil {
+		t.Fatalf("SaveGrant error: %v", err)
+	}
+
+	taken, err := repo.TakeGrant("grant-id")
+	if err != nil {
+		t.Fatalf("TakeGrant error: %v", err)
+	}
+	if taken.Username != grant.Username || taken.Scope != grant.Scope {
+		t.Errorf("Expected %+v, got %+v", grant, taken)
+	}
+
+	if _, err := repo.TakeGrant("grant-id"); err != ErrInvalidGrant {
+		t.Errorf("Expected ErrInvalidGrant after the grant was taken, got %v", err)
+	}
+}
+
+func TestRedisCodeRepository_ListCodes(t *testing.T) {
+	repo := newTestRedisRepository(t)
+	for _, username := range []string{"user1", "user2", "user3"} {
+		_, _ = repo.SaveCode(username, "123456", "test_scope1", time.Minute)
+	}
+	_, _ = repo.SaveCode("user1", "123456", "test_scope2", time.Minute)
+	_ = repo.SaveGrant("grant", &Grant{Username: "user1", Scope: "test_scope1"}, time.Minute)
+	repo.client.Set(repo.ctx, repo.createKeyScope("user4", "test_scope1"), "not a code", time.Minute)
+
+	usernames := map[string]bool{}
+	options := ListOptions{Scope: "test_scope1", Limit: 1}
+	for {
+		page, err := repo.ListCodes(options)
+		if err != nil {
+			t.Fatalf("ListCodes error: %v", err)
+		}
+		for _, code := range page.Codes {
+			if code.Scope != "test_scope1" || code.Code != "" || usernames[code.Username] {
+				t.Errorf("Unexpected code %+v", code)
+			}
+			usernames[code.Username] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		options.Cursor = page.NextCursor
+	}
+	if len(usernames) != 3 {
+		t.Errorf("Expected codes of 3 users, but got %v", usernames)
+	}
+
+	page, err := repo.ListCodes(ListOptions{Username: "user1", WithCodes: true, Limit: 100})
+	if err != nil {
+		t.Fatalf("ListCodes error: %v", err)
+	}
+	if len(page.Codes) != 2 || page.Codes[0].Code != "123456" {
+		t.Errorf("Expected both codes of user1, but got %+v", page.Codes)
+	}
+}
+
+func TestRedisCodeRepository_PlainKeyColon(t *testing.T) {
+	repo := newTestRedisRepository(t)
+	if _, err := repo.SaveCode("c", "222222", "a:b", time.Minute); !errors.Is(err, ErrInvalidKeyComponent) {
+		t.Errorf("Expected ErrInvalidKeyComponent for a scope with ':', but got %v", err)
+	}
+	if _, err := repo.SaveCodeIfAbsent("b:c", "222222", "a", time.Minute, nil); !errors.Is(err, ErrInvalidKeyComponent) {
+		t.Errorf("Expected ErrInvalidKeyComponent for a username with ':', but got %v", err)
+	}
+	if repo.DeleteCode("c", "a:b") {
+		t.Error("Expected DeleteCode to refuse a scope with ':'")
+	}
+}
+
+func TestRedisCodeRepository_DeleteScope(t *testing.T) {
+	repo := newTestRedisRepository(t)
+
+	for i := 0; i < 1200; i++ {
+		if _, err := repo.SaveCode(fmt.Sprintf("user%d", i), "123456", "test_scope1", time.Minute); err != nil {
+			t.Fatalf("SaveCode error: %v", err)
+		}
+	}
+	_, _ = repo.SaveCode("user1", "123456", "test_scope2", time.Minute)
+
+	deleted, err := repo.DeleteScope("test_scope1")
+	if err != nil || deleted != 1200 {
+		t.Errorf("Expected 1200 deleted codes, but got %d, %v", deleted, err)
+	}
+	if _, err := repo.GetCode("user1", "test_scope1"); err == nil {
+		t.Error("GetCode expected to return an error after deletion")
+	}
+	if _, err := repo.GetCode("user1", "test_scope2"); err != nil {
+		t.Errorf("Expected other scopes to stay, got %v", err)
+	}
+}
+
+func TestRedisCodeRepository_DeleteScopeInternalKeys(t *testing.T) {
+	repo := newTestRedisRepository(t)
+	issuer := NewStoredGrantIssuer(repo)
+	grant, err := issuer.Issue("testuser", "forget-password", time.Minute)
+	if err != nil {
+		t.Fatalf("Issue error: %v", err)
+	}
+	recovery, _ := NewRecoveryCodes(mustGenerator(NewCharsetGenerator(CrockfordCharset, 12, "")), repo, RecoveryOptions{})
+	if _, err := recovery.Generate("testuser"); err != nil {
+		t.Fatalf("Generate error: %v", err)
+	}
+	_, _ = repo.SaveCode("testuser", "123456", "grant", time.Minute)
+
+	for _, scope := range []string{"grant", "recovery"} {
+		if _, err := repo.DeleteScope(scope); err != nil {
+			t.Fatalf("DeleteScope error: %v", err)
+		}
+	}
+	if _, err := issuer.Peek(grant.Token, "forget-password"); err != nil {
+		t.Errorf("Expected the grant to stay, but got %v", err)
+	}
+	if remaining, err := recovery.Remaining("testuser"); err != nil || remaining != DefaultRecoveryCodeCount {
+		t.Errorf("Expected %d recovery codes, but got %d, %v", DefaultRecoveryCodeCount, remaining, err)
+	}
+	if _, err := repo.GetCode("testuser", "grant"); err == nil {
+		t.Error("Expected the code of the grant scope to be deleted")
+	}
+	_ = recovery.Delete("testuser")
+	_, _ = issuer.Redeem(grant.Token, "forget-password")
+}
+
+func TestRedisCodeRepository_WithTenant(t *testing.T) {
+	repo := newTestRedisRepository(t)
+	brandA, err := repo.WithTenant("brand-a")
+	if err != nil {
+		t.Fatalf("WithTenant error: %v", err)
+	}
+	brandB, _ := repo.WithTenant("brand-b")
+
+	_, _ = brandA.SaveCode("testuser", "111111", "login", time.Minute)
+	_, _ = brandB.SaveCode("testuser", "222222", "login", time.Minute)
+
+	code, err := brandA.GetCode("testuser", "login")
+	if err != nil || code.Code != "111111" {
+		t.Errorf("Expected the code of brand-a, got %+v, %v", code, err)
+	}
+	brandA.DeleteAllCodes("testuser")
+	if _, err := brandA.DeleteScope("login"); err != nil {
+		t.Fatalf("DeleteScope error: %v", err)
+	}
+	code, err = brandB.GetCode("testuser", "login")
+	if err != nil || code.Code != "222222" {
+		t.Errorf("Expected the code of brand-b to stay, got %+v, %v", code, err)
+	}
+	if _, err := repo.WithTenant("brand*"); err == nil {
+		t.Error("Expected an error for an invalid tenant")
+	}
+	brandB.DeleteAllCodes("testuser")
+}
+
+func TestRedisCodeRepository_TenantIsolation(t *testing.T) {
+	repo := newTestRedisRepository(t)
+	acme, _ := repo.WithTenant("acme")
+
+	_, _ = acme.SaveCode("testuser", "111111", "login", time.Minute)
+	_, _ = repo.SaveCode("testuser", "222222", "login", time.Minute)
+
+	if !repo.DeleteAllCodes("testuser") {
+		t.Fatal("DeleteAllCodes failed")
+	}
+	if deleted, err := repo.DeleteScope("{acme}"); err != nil || deleted != 0 {
+		t.Errorf("Expected no deleted codes for the tenant prefix, but got %d, %v", deleted, err)
+	}
+	if _, err := repo.GetCode("testuser", "login"); err == nil {
+		t.Error("Expected the code without tenant to be deleted")
+	}
+	code, err := acme.GetCode("testuser", "login")
+	if err != nil || code.Code != "111111" {
+		t.Errorf("Expected the code of the tenant to stay, but got %+v, %v", code, err)
+	}
+	acme.DeleteAllCodes("testuser")
+}
+
+func TestRedisCodeRepository_KeyBuilder(t *testing.T) {
+	keys, _ := NewEscapedKeyBuilder([]byte("0123456789abcdef"))
+	repo := newTestRedisRepositoryWithConfig(t, RedisConfig{KeyBuilder: keys})
+	plain := newTestRedisRepository(t)
+
+	for _, r := range []*RedisCodeRepository{repo, plain} {
+		_, _ = r.SaveCode("user*", "111111", "login", time.Minute)
+		_, _ = r.SaveCode("user1", "222222", "login", time.Minute)
+
+		r.DeleteAllCodes("user*")
+		if _, err := r.GetCode("user*", "login"); err == nil {
+			t.Error("GetCode expected to return an error after deletion")
+		}
+		code, err := r.GetCode("user1", "login")
+		if err != nil || code.Code != "222222" {
+			t.Errorf("Expected a glob in a username to match only itself, got %+v, %v", code, err)
+		}
+
+		page, err := r.ListCodes(ListOptions{Username: "user1"})
+		if err != nil || len(page.Codes) != 1 {
+			t.Errorf("Expected 1 listed code, got %+v, %v", page, err)
+		}
+		r.DeleteAllCodes("user1")
+	}
+
+	if repo.DeleteAllCodes("") {
+		t.Error("Expected DeleteAllCodes to refuse an empty username")
+	}
+	if _, err := repo.DeleteScope(""); err == nil {
+		t.Error("Expected DeleteScope to refuse an empty scope")
+	}
+}
+
+func TestRedisCodeRepository_Codec(t *testing.T) {
+	binary, _ := NewEnvelopeCodec(BinaryFormat)
+	legacy := newTestRedisRepository(t)
+	repo := newTestRedisRepositoryWithConfig(t, RedisConfig{Prefix: legacy.prefix, Codec: binary})
+
+	_, _ = legacy.SaveCode("user1", "111111", "login", time.Minute)
+	_, _ = repo.SaveCode("user2", "222222", "login", time.Minute)
+
+	for _, r := range []*RedisCodeRepository{legacy, repo} {
+		first, err := r.GetCode("user1", "login")
+		if err != nil || first.Code != "111111" {
+			t.Errorf("Expected the JSON record, got %+v, %v", first, err)
+		}
+		second, err := r.GetCode("user2", "login")
+		if err != nil || second.Code != "222222" || second.ExpireAfter <= 0 {
+			t.Errorf("Expected the binary record, got %+v, %v", second, err)
+		}
+	}
+	_, _ = repo.DeleteScope("login")
+}
+
+func TestRedisCodeRepository_Encryption(t *testing.T) {
+	ctx := context.TODO()
+	keys, _ := NewEncryptionKeys("1", map[string][]byte{"1": make([]byte, 32)})
+	repo := newTestRedisRepositoryWithConfig(t, RedisConfig{Codec: NewEncryptingCodec(nil, keys)})
+
+	_, _ = repo.SaveCode("user@example.com", "123456", "login", time.Minute)
+	raw, err := repo.client.Get(ctx, repo.createKeyScope("user@example.com", "login")).Result()
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	if strings.Contains(raw, "user@example.com") || strings.Contains(raw, "123456") {
+		t.Error("Expected the record to be encrypted")
+	}
+	code, err := repo.GetCode("user@example.com", "login")
+	if err != nil || code.Code != "123456" {
+		t.Errorf("Expected the decrypted code, got %+v, %v", code, err)
+	}
+
+	// the key is the associated data, so a record copied under another key doesn't open
+	_ = repo.client.Set(ctx, repo.createKeyScope("user@example.com", "transfer"), raw, time.Minute).Err()
+	if _, err := repo.GetCode("user@example.com", "transfer"); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed for a copied record, got %v", err)
+	}
+	repo.DeleteCode("user@example.com", "transfer")
+	repo.DeleteAllCodes("user@example.com")
+}
+
+func TestRedisCodeRepository_Metadata(t *testing.T) {
+	repo := newTestRedisRepository(t)
+	defer repo.DeleteAllCodes("user@example.com")
+
+	_, err := repo.SaveCodeWithMetadata("user@example.com", "123456", "login", time.Minute, map[string]string{"device": "abc"})
+	if err != nil {
+		t.Fatalf("SaveCodeWithMetadata error: %v", err)
+	}
+	code, err := repo.GetCode("user@example.com", "login")
+	if err != nil || code.Metadata["device"] != "abc" {
+		t.Errorf("Expected the metadata to be stored, got %+v, %v", code, err)
+	}
+}
@@ -0,0 +1,173 @@
+package go_verification
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultResendQuietPeriod is how long nothing must be sent before a ResendBackoff starts
+// over.
+const DefaultResendQuietPeriod = 30 * time.Minute
+
+var ErrResendTooSoon = errors.New("resend is not allowed yet")
+
+// ResendTooSoonError is returned by GenerateCode and RegenerateCode while the resend
+// backoff of a username and scope runs. errors.Is matches it with ErrResendTooSoon.
+type ResendTooSoonError struct {
+	NextResendAt time.Time
+}
+
+func (e *ResendTooSoonError) Error() string {
+	return fmt.Sprintf("%s, retry at %s", ErrResendTooSoon, e.NextResendAt.Format(time.RFC3339))
+}
+
+func (e *ResendTooSoonError) Is(target error) bool {
+	return target == ErrResendTooSoon
+}
+
+// RetryAfter makes the HTTP and gRPC APIs answer with a rate limit.
+func (e *ResendTooSoonError) RetryAfter() time.Duration {
+	return time.Until(e.NextResendAt)
+}
+
+// ResendBackoff makes the wait between sends of a code grow, e.g. 30s, 1m, 2m, 5m.
+type ResendBackoff struct {
+	// Intervals are the waits after the first, second... send, the last one repeats.
+	Intervals []time.Duration
+	// QuietPeriod starts the intervals over when nothing was sent for that long. It
+	// defaults to DefaultResendQuietPeriod and can't be shorter than the longest interval.
+	QuietPeriod time.Duration
+}
+
+// ResendStore keeps where the ResendBackoff of usernames and scopes is.
+// RedisCodeRepository and MemoryCodeRepository implement it, EncryptedCodeRepository
+// forwards it.
+type ResendStore interface {
+	// TakeResend records a send of username for scope and returns when the next one is
+	// allowed, or fails with a ResendTooSoonError. It must be atomic.
+	TakeResend(username, scope string, backoff ResendBackoff) (time.Time, error)
+	// ResetResend starts the backoff of username for scope over.
+	ResetResend(username, scope string) error
+	// ReleaseResend gives back the send TakeResend returned next for when no code was
+	// sent, unless another send happened since.
+	ReleaseResend(username, scope string, next time.Time) error
+}
+
+// resendState is what a ResendStore keeps per username and scope.
+type resendState struct {
+	Count  int
+	SentAt time.Time
+	NextAt time.Time
+	// Previous is the state before the last send, for release.
+	Previous *resendState `json:",omitempty"`
+}
+
+func (b ResendBackoff) enabled() bool {
+	return len(b.Intervals) > 0
+}
+
+func (b ResendBackoff) check() error {
+	var longest time.Duration
+	for _, interval := range b.Intervals {
+		if interval <= 0 {
+			return fmt.Errorf("invalid resend interval %s", interval)
+		}
+		if interval > longest {
+			longest = interval
+		}
+	}
+	if b.QuietPeriod < 0 || b.QuietPeriod != 0 && b.QuietPeriod < longest {
+		return fmt.Errorf("resend quiet period %s is shorter than the longest interval %s", b.QuietPeriod, longest)
+	}
+	if b.QuietPeriod == 0 && DefaultResendQuietPeriod < longest {
+		return fmt.Errorf("resend interval %s is longer than the default quiet period, set one", longest)
+	}
+	return nil
+}
+
+func (b ResendBackoff) quietPeriod() time.Duration {
+	if b.QuietPeriod == 0 {
+		return DefaultResendQuietPeriod
+	}
+	return b.QuietPeriod
+}
+
+// take records a send at now in state.
+func (b ResendBackoff) take(state resendState, now time.Time) (resendState, error) {
+	previous := state
+	previous.Previous = nil
+	if now.Sub(state.SentAt) >= b.quietPeriod() {
+		state = resendState{}
+	}
+	if now.Before(state.NextAt) {
+		return state, &ResendTooSoonError{NextResendAt: state.NextAt}
+	}
+	step := state.Count
+	if step >= len(b.Intervals) {
+		step = len(b.Intervals) - 1
+	}
+	state.Count++
+	state.SentAt = now
+	state.NextAt = now.Add(b.Intervals[step])
+	state.Previous = &previous
+	return state, nil
+}
+
+// release returns state before the send that returned next, and false when another send
+// happened since.
+func (state resendState) release(next time.Time) (resendState, bool) {
+	if state.Previous == nil || !state.NextAt.Equal(next) {
+		return state, false
+	}
+	return *state.Previous, true
+}
+
+// resendBackoff returns the backoff of scope.
+func (v *VerificationCodeHandler) resendBackoff(scope string) ResendBackoff {
+	if policy, ok := v.config.Scopes[scope]; ok && policy.ResendBackoff.enabled() {
+		return policy.ResendBackoff
+	}
+	return v.config.ResendBackoff
+}
+
+// takeResend records a send, it returns a zero time when scope has no backoff.
+func (v *VerificationCodeHandler) takeResend(username, scope string) (time.Time, error) {
+	backoff := v.resendBackoff(scope)
+	if !backoff.enabled() {
+		return time.Time{}, nil
+	}
+	return v.repository.(ResendStore).TakeResend(username, scope, backoff)
+}
+
+// releaseResend gives back a send taken by takeResend when no code was sent.
+func (v *VerificationCodeHandler) releaseResend(username, scope string, next time.Time) {
+	if next.IsZero() {
+		return
+	}
+	_ = v.repository.(ResendStore).ReleaseResend(username, scope, next)
+}
+
+func (v *VerificationCodeHandler) resetResend(username, scope string) error {
+	if !v.resendBackoff(scope).enabled() {
+		return nil
+	}
+	return v.repository.(ResendStore).ResetResend(username, scope)
+}
+
+func checkResendBackoff(repository CodeRepositoryInterface, config *Config) error {
+	if err := config.ResendBackoff.check(); err != nil {
+		return err
+	}
+	enabled := config.ResendBackoff.enabled()
+	for scope, policy := range config.Scopes {
+		if err := policy.ResendBackoff.check(); err != nil {
+			return fmt.Errorf("scope %s: %w", scope, err)
+		}
+		enabled = enabled || policy.ResendBackoff.enabled()
+	}
+	if enabled && !supports[ResendStore](repository) {
+		return errors.New("repository does not support resend backoff")
+	}
+	return nil
+}
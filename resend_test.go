@@ -0,0 +1,139 @@
+package go_verification
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestResendBackoff_Take(t *testing.T) {
+	backoff := ResendBackoff{Intervals: []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 5 * time.Minute}}
+	now := time.Unix(1700000000, 0)
+	var state resendState
+
+	for _, expected := range []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		var err error
+		if state, err = backoff.take(state, now); err != nil {
+			t.Fatalf("take error: %v", err)
+		}
+		if state.NextAt.Sub(now) != expected {
+			t.Errorf("Expected to wait %s, but got %s", expected, state.NextAt.Sub(now))
+		}
+		if _, err := backoff.take(state, state.NextAt.Add(-time.Second)); !errors.Is(err, ErrResendTooSoon) {
+			t.Errorf("Expected ErrResendTooSoon, but got %v", err)
+		}
+		now = state.NextAt
+	}
+
+	state, _ = backoff.take(state, now.Add(DefaultResendQuietPeriod))
+	if state.Count != 1 || state.NextAt.Sub(state.SentAt) != 30*time.Second {
+		t.Errorf("Expected the backoff to start over after the quiet period, got %+v", state)
+	}
+}
+
+func TestVerificationCodeHandler_ResendBackoff(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, repository CodeRepositoryInterface) {
+		config := &Config{ResendBackoff: ResendBackoff{Intervals: []time.Duration{time.Minute, 5 * time.Minute}}}
+		handler, err := NewVerificationCodeHandler(&MockCodeGenerator{defCode: "123456"}, repository, config)
+		if err != nil {
+			t.Fatalf("NewVerificationCodeHandler error: %v", err)
+		}
+		defer repository.(ResendStore).ResetResend("testuser", "login")
+		defer repository.DeleteAllCodes("testuser")
+
+		code, err := handler.GenerateCode("testuser", "login")
+		if err != nil {
+			t.Fatalf("GenerateCode error: %v", err)
+		}
+		if wait := time.Until(code.NextResendAt); wait < 59*time.Second || wait > time.Minute {
+			t.Errorf("Expected the next resend in a minute, got %s", wait)
+		}
+
+		_, err = handler.RegenerateCode("testuser", "login", false)
+		var tooSoon *ResendTooSoonError
+		if !errors.As(err, &tooSoon) || !errors.Is(err, ErrResendTooSoon) {
+			t.Fatalf("Expected a ResendTooSoonError, but got %v", err)
+		}
+		if !tooSoon.NextResendAt.Equal(code.NextResendAt) || tooSoon.RetryAfter() <= 0 {
+			t.Errorf("Expected to retry at %s, but got %s", code.NextResendAt, tooSoon.NextResendAt)
+		}
+
+		if valid, err := handler.CheckCode("testuser", "123456", "login"); !valid {
+			t.Fatalf("CheckCode error: %v", err)
+		}
+		if _, err := handler.GenerateCode("testuser", "login"); err != nil {
+			t.Errorf("Expected a successful check to reset the backoff, got %v", err)
+		}
+	})
+}
+
+func TestVerificationCodeHandler_ResendFailedSend(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, repository CodeRepositoryInterface) {
+		config := &Config{ResendBackoff: ResendBackoff{Intervals: []time.Duration{time.Minute, 5 * time.Minute}}}
+		generator := &MockCodeGenerator{}
+		handler, err := NewVerificationCodeHandler(generator, repository, config)
+		if err != nil {
+			t.Fatalf("NewVerificationCodeHandler error: %v", err)
+		}
+		defer repository.(ResendStore).ResetResend("testuser", "login")
+		defer repository.DeleteAllCodes("testuser")
+
+		if _, err := handler.GenerateCode("testuser", "login"); err == nil {
+			t.Fatal("Expected GenerateCode to fail with an empty code")
+		}
+		generator.defCode = "123456"
+		code, err := handler.GenerateCode("testuser", "login")
+		if err != nil {
+			t.Fatalf("Expected a failed send to give back the resend, but got %v", err)
+		}
+		if wait := time.Until(code.NextResendAt); wait > time.Minute {
+			t.Errorf("Expected the next resend in a minute, got %s", wait)
+		}
+
+		// a failed resend gives back its slot without replacing the code
+		config.ResendBackoff.Intervals = []time.Duration{time.Millisecond, 10 * time.Minute}
+		if handler, err = NewVerificationCodeHandler(generator, repository, config); err != nil {
+			t.Fatalf("NewVerificationCodeHandler error: %v", err)
+		}
+		if err := repository.(ResendStore).ResetResend("testuser", "login"); err != nil {
+			t.Fatalf("ResetResend error: %v", err)
+		}
+		if _, err := handler.GenerateCode("testuser", "login"); err != nil {
+			t.Fatalf("GenerateCode error: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		generator.defCode = ""
+		if _, err := handler.RegenerateCode("testuser", "login", false); err == nil {
+			t.Fatal("Expected RegenerateCode to fail with an empty code")
+		}
+		generator.defCode = "654321"
+		code, err = handler.RegenerateCode("testuser", "login", false)
+		if err != nil {
+			t.Fatalf("Expected a failed resend to give back its slot, but got %v", err)
+		}
+		if wait := time.Until(code.NextResendAt); wait < 9*time.Minute {
+			t.Errorf("Expected the next resend in 10 minutes, got %s", wait)
+		}
+	})
+}
+
+func TestNewVerificationCodeHandler_ResendBackoff(t *testing.T) {
+	tests := []struct {
+		name       string
+		repository CodeRepositoryInterface
+		backoff    ResendBackoff
+	}{
+		{"unsupported repository", NewMockCodeRepository(), ResendBackoff{Intervals: []time.Duration{time.Minute}}},
+		{"negative interval", NewMemoryCodeRepository(), ResendBackoff{Intervals: []time.Duration{-time.Minute}}},
+		{"short quiet period", NewMemoryCodeRepository(), ResendBackoff{Intervals: []time.Duration{time.Hour}, QuietPeriod: time.Minute}},
+		{"long interval", NewMemoryCodeRepository(), ResendBackoff{Intervals: []time.Duration{time.Hour}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &Config{Scopes: map[string]ScopePolicy{"login": {ResendBackoff: test.backoff}}}
+			if _, err := NewVerificationCodeHandler(&MockCodeGenerator{defCode: "123456"}, test.repository, config); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package go_verification
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+var ErrTenantsUnsupported = errors.New("repository does not support tenants")
+
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// TenantPolicy overrides the handler Config for one tenant. Zero fields keep the
+// values of the handler.
+type TenantPolicy struct {
+	ExpiredAfterSec time.Duration
+	// Scopes are merged over Config.Scopes.
+	Scopes    map[string]ScopePolicy
+	Generator CodeGenerator
+}
+
+// TenantRepository is implemented by repositories that can keep the codes of tenants
+// apart. RedisCodeRepository and MemoryCodeRepository implement it.
+type TenantRepository interface {
+	// WithTenant returns a repository whose codes, listings and deletions only see tenant.
+	WithTenant(tenant string) (CodeRepositoryInterface, error)
+}
+
+// ForTenant returns a handler that works on the codes of tenant only, with the
+// Config.Tenants policy of tenant applied. Tenants are 1 to 64 letters, digits, '_' or '-'.
+func (v *VerificationCodeHandler) ForTenant(tenant string) (*VerificationCodeHandler, error) {
+	if v.tenant != "" {
+		return nil, fmt.Errorf("handler is already bound to tenant %q", v.tenant)
+	}
+	if err := checkTenant(tenant); err != nil {
+		return nil, err
+	}
+	tenants, ok := v.repository.(TenantRepository)
+	if !ok {
+		return nil, ErrTenantsUnsupported
+	}
+	repository, err := tenants.WithTenant(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	config := *v.config
+	config.Scopes = make(map[string]ScopePolicy, len(v.config.Scopes))
+	for scope, policy := range v.config.Scopes {
+		config.Scopes[scope] = policy
+	}
+	generator := v.generator
+	if policy, ok := v.config.Tenants[tenant]; ok {
+		if policy.ExpiredAfterSec != 0 {
+			config.ExpiredAfterSec = policy.ExpiredAfterSec
+		}
+		for scope, scopePolicy := range policy.Scopes {
+			config.Scopes[scope] = scopePolicy
+		}
+		if policy.Generator != nil {
+			generator = policy.Generator
+		}
+	}
+	config.Tenants = nil
+
+	handler, err := NewVerificationCodeHandler(generator, repository, &config)
+	if err != nil {
+		return nil, fmt.Errorf("tenant %s: %w", tenant, err)
+	}
+	handler.tenant = tenant
+	return handler, nil
+}
+
+// Tenant returns the tenant of a handler made by ForTenant.
+func (v *VerificationCodeHandler) Tenant() string {
+	return v.tenant
+}
+
+// grantScope keeps the grants of tenants apart when they share a GrantIssuer.
+func (v *VerificationCodeHandler) grantScope(scope string) string {
+	if v.tenant == "" {
+		return scope
+	}
+	return "{" + v.tenant + "}" + scope
+}
+
+func checkTenant(tenant string) error {
+	if !tenantPattern.MatchString(tenant) {
+		return fmt.Errorf("invalid tenant %q", tenant)
+	}
+	return nil
+}
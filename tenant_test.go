@@ -0,0 +1,111 @@
+package go_verification
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerificationCodeHandler_ForTenant(t *testing.T) {
+	repository := NewMemoryCodeRepository()
+	handler, err := NewVerificationCodeHandler(&MockCodeGenerator{defCode: "123456"}, repository, &Config{
+		ExpiredAfterSec: 5 * time.Minute,
+		GrantIssuer:     NewStoredGrantIssuer(repository),
+		Tenants: map[string]TenantPolicy{
+			"brand-b": {
+				ExpiredAfterSec: 3 * time.Minute,
+				Generator:       &MockCodeGenerator{defCode: "654321"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create VerificationCodeHandler: %v", err)
+	}
+
+	brandA, err := handler.ForTenant("brand-a")
+	if err != nil {
+		t.Fatalf("ForTenant error: %v", err)
+	}
+	brandB, err := handler.ForTenant("brand-b")
+	if err != nil {
+		t.Fatalf("ForTenant error: %v", err)
+	}
+	if brandB.Tenant() != "brand-b" {
+		t.Errorf("Expected tenant brand-b, but got %q", brandB.Tenant())
+	}
+
+	t.Run("policy", func(t *testing.T) {
+		codeA, _ := brandA.GenerateCode("testuser", "login")
+		codeB, _ := brandB.GenerateCode("testuser", "login")
+		if codeA.Code != "123456" || codeA.ExpireAfter != 300 {
+			t.Errorf("Expected the handler policy for brand-a, got %+v", codeA)
+		}
+		if codeB.Code != "654321" || codeB.ExpireAfter != 180 {
+			t.Errorf("Expected the brand-b policy, got %+v", codeB)
+		}
+	})
+
+	t.Run("isolation", func(t *testing.T) {
+		if _, err := handler.GetCode("testuser", "login"); !errors.Is(err, ErrCodeNotFound) {
+			t.Errorf("Expected tenant codes to be invisible to the handler, got %v", err)
+		}
+		brandA.Repository().DeleteAllCodes("testuser")
+		if deleted, _ := brandA.DeleteScope("login"); deleted != 0 {
+			t.Errorf("Expected nothing left for brand-a, but deleted %d", deleted)
+		}
+		if _, err := brandB.GetCode("testuser", "login"); err != nil {
+			t.Errorf("Expected the code of brand-b to stay, got %v", err)
+		}
+		page, _ := brandB.ListCodes(ListOptions{})
+		if len(page.Codes) != 1 {
+			t.Errorf("Expected 1 code for brand-b, but got %d", len(page.Codes))
+		}
+	})
+
+	t.Run("grants", func(t *testing.T) {
+		result, err := brandB.VerifyCode("testuser", "654321", "login")
+		if err != nil {
+			t.Fatalf("VerifyCode error: %v", err)
+		}
+		if result.Grant.Scope != "login" {
+			t.Errorf("Expected the grant scope login, but got %q", result.Grant.Scope)
+		}
+		if _, err := brandA.PeekGrant(result.Grant.Token, "login"); !errors.Is(err, ErrInvalidGrant) {
+			t.Errorf("Expected grants of brand-b to be invalid for brand-a, got %v", err)
+		}
+		if _, err := handler.PeekGrant(result.Grant.Token, "login"); !errors.Is(err, ErrInvalidGrant) {
+			t.Errorf("Expected grants of brand-b to be invalid for the handler, got %v", err)
+		}
+		grant, err := brandB.RedeemGrant(result.Grant.Token, "login")
+		if err != nil || grant.Scope != "login" {
+			t.Errorf("Expected the grant to be redeemed, got %+v, %v", grant, err)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for _, tenant := range []string{"", "brand:a", "brand*", "brand a"} {
+			if _, err := handler.ForTenant(tenant); err == nil {
+				t.Errorf("Expected an error for tenant %q", tenant)
+			}
+		}
+		if _, err := brandA.ForTenant("brand-b"); err == nil {
+			t.Error("Expected an error for a handler already bound to a tenant")
+		}
+		unsupported, _ := NewVerificationCodeHandler(&MockCodeGenerator{defCode: "123456"}, NewMockCodeRepository(), &Config{})
+		if _, err := unsupported.ForTenant("brand-a"); !errors.Is(err, ErrTenantsUnsupported) {
+			t.Errorf("Expected ErrTenantsUnsupported, got %v", err)
+		}
+	})
+}
+
+func TestNewVerificationCodeHandler_TenantGeneratorStrength(t *testing.T) {
+	weak, _ := NewNumberGenerator(4, false)
+	strong, _ := NewNumberGenerator(8, false)
+	_, err := NewVerificationCodeHandler(strong, NewMemoryCodeRepository(), &Config{
+		MaxGuessProbability: 0.0001,
+		Tenants:             map[string]TenantPolicy{"brand-a": {Generator: weak}},
+	})
+	if err == nil {
+		t.Error("Expected an error for a weak tenant generator")
+	}
+}
@@ -4,9 +4,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"strings"
 	"time"
 )
 
+var (
+	ErrCodeNotFound = errors.New("does not exist")
+	ErrCodeExpired  = errors.New("code expired")
+	ErrInvalidCode  = errors.New("invalid code")
+)
+
 type Duration time.Duration
 
 func (d Duration) MarshalJSON() ([]byte, error) {
@@ -34,8 +42,60 @@ func (d *Duration) UnmarshalJSON(b []byte) error {
 	}
 }
 
+// UnmarshalYAML lets YAML packages decode durations like "2m" without importing one here.
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	tmp, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(tmp)
+	return nil
+}
+
 type Config struct {
 	ExpiredAfterSec time.Duration
+	// MaxAttempts is how many codes an attacker can try before a code is gone. It is used with
+	// MaxGuessProbability and defaults to 1. The handler doesn't count failed checks, callers
+	// must enforce it, e.g. by deleting the code after MaxAttempts failures.
+	MaxAttempts int
+	// MaxGuessProbability makes NewVerificationCodeHandler refuse generators whose chance to be
+	// guessed in MaxAttempts tries is higher than it, e.g. 0.0001. Zero disables the check.
+	MaxGuessProbability float64
+	// Scopes overrides the options above for single scopes.
+	Scopes map[string]ScopePolicy
+	// GrantIssuer makes VerifyCode issue a grant after a successful verification.
+	GrantIssuer GrantIssuer
+	// GrantTTL is how long a grant can be redeemed, 5 minutes by default.
+	GrantTTL time.Duration
+	// Tenants overrides the options above for the handlers returned by ForTenant.
+	Tenants map[string]TenantPolicy
+	// ValidCodes keeps the codes replaced by RegenerateCode valid until they expire, so a
+	// late SMS still works. The last ValidCodes codes are valid and a successful check
+	// consumes them all. The repository must implement PreviousCodeStore.
+	ValidCodes int
+	// ResendBackoff makes GenerateCode and RegenerateCode fail with a ResendTooSoonError
+	// when they are called again too soon for a username and scope. A successful check
+	// starts it over. The repository must implement ResendStore.
+	ResendBackoff ResendBackoff
+	// AbuseGuard is asked by GenerateCode and RegenerateCode before a code is sent, with
+	// the attributes of WithRequestAttributes. Denied requests fail with an AbuseError.
+	AbuseGuard AbuseGuard
+}
+
+type ScopePolicy struct {
+	// ExpiredAfterSec overrides Config.ExpiredAfterSec when it is not zero.
+	ExpiredAfterSec time.Duration
+	// Binding makes codes of the scope fail with ErrBindingMismatch when they are presented
+	// from another session, device or network than the one of WithBinding.
+	Binding BindingPolicy
+	// ValidCodes overrides Config.ValidCodes when it is not zero.
+	ValidCodes int
+	// ResendBackoff overrides Config.ResendBackoff when it has intervals.
+	ResendBackoff ResendBackoff
 }
 
 type VerificationCode struct {
@@ -45,16 +105,53 @@ type VerificationCode struct {
 	Username    string
 	Scope       string
 	Code        string
+	// Metadata is set with WithMetadata when the code is generated.
+	Metadata map[string]string `json:",omitempty"`
+	// NextResendAt is when GenerateCode or RegenerateCode can send the code again, it is
+	// only set with Config.ResendBackoff.
+	NextResendAt time.Time `json:"-"`
+}
+
+// Verification is the result of a successful VerifyCode.
+type Verification struct {
+	Code *VerificationCode
+	// Grant is nil when Config.GrantIssuer is not set.
+	Grant *Grant
 }
 
 type VerificationCodeHandler struct {
 	repository CodeRepositoryInterface
 	generator  CodeGenerator
 	config     *Config
+	tenant     string
 }
 
 func NewVerificationCodeHandler(generator CodeGenerator, repository CodeRepositoryInterface, options *Config) (*VerificationCodeHandler, error) {
 	checkConfig(options)
+	if err := checkGeneratorStrength(generator, options); err != nil {
+		return nil, err
+	}
+	if err := checkValidCodes(repository, options); err != nil {
+		return nil, err
+	}
+	if err := checkResendBackoff(repository, options); err != nil {
+		return nil, err
+	}
+	for scope, policy := range options.Scopes {
+		if err := policy.Binding.check(); err != nil {
+			return nil, fmt.Errorf("scope %s: %w", scope, err)
+		}
+	}
+	for tenant, policy := range options.Tenants {
+		if err := checkTenant(tenant); err != nil {
+			return nil, err
+		}
+		if policy.Generator != nil {
+			if err := checkGeneratorStrength(policy.Generator, options); err != nil {
+				return nil, fmt.Errorf("tenant %s: %w", tenant, err)
+			}
+		}
+	}
 
 	return &VerificationCodeHandler{
 		repository: repository,
@@ -63,76 +160,326 @@ func NewVerificationCodeHandler(generator CodeGenerator, repository CodeReposito
 	}, nil
 }
 
-func (v *VerificationCodeHandler) GenerateCode(username, scope string) (*VerificationCode, error) {
+// GenerateCode returns the active code of username for scope or saves a new one. A code
+// with other metadata than the one of WithMetadata is replaced, so a code sent for one
+// email address can't confirm another. With a ConditionalSaver repository concurrent
+// calls return the same code.
+func (v *VerificationCodeHandler) GenerateCode(username, scope string, options ...GenerateOption) (*VerificationCode, error) {
+	var generate generateOptions
+	for _, option := range options {
+		option(&generate)
+	}
+	if err := v.bind(&generate, scope); err != nil {
+		return nil, err
+	}
+	if generate.idempotencyKey == "" {
+		return v.sendCode(username, scope, generate)
+	}
+	if result, err := v.reserveIdempotencyKey(username, scope, generate); result != nil || err != nil {
+		return result, err
+	}
+	verify, err := v.sendCode(username, scope, generate)
+	if err != nil {
+		v.releaseIdempotencyKey(username, scope, generate)
+		return nil, err
+	}
+	return v.saveIdempotentResult(verify, generate)
+}
+
+// sendCode checks the request and returns the code GenerateCode sends.
+func (v *VerificationCodeHandler) sendCode(username, scope string, generate generateOptions) (*VerificationCode, error) {
+	releaseAbuse, err := v.checkAbuse(username, scope, generate.attributes)
+	if err != nil {
+		return nil, err
+	}
+	next, err := v.takeResend(username, scope)
+	if err != nil {
+		releaseAbuse()
+		return nil, err
+	}
+	verify, err := v.generateCode(username, scope, generate.metadata)
+	if err != nil {
+		// nothing is sent, so the request is given back
+		v.releaseResend(username, scope, next)
+		releaseAbuse()
+		return nil, err
+	}
+	verify.NextResendAt = next
+	return verify, nil
+}
+
+func (v *VerificationCodeHandler) generateCode(username, scope string, metadata map[string]string) (*VerificationCode, error) {
 	verify, err := v.repository.GetCode(username, scope)
-	if err == nil {
+	if err == nil && sameMetadata(verify.Metadata, metadata) {
 		return verify, nil
 	}
 
-	code := v.generator.Generate()
-	verify, err = v.repository.SaveCode(username, code, scope, v.config.ExpiredAfterSec)
+	if errors.Is(err, ErrCodeNotFound) {
+		if err := v.forgetPrevious(username, scope); err != nil {
+			return nil, err
+		}
+	}
+
+	code, err := v.generate()
 	if err != nil {
 		return nil, err
 	}
-	return verify, nil
+	if verify != nil {
+		return v.save(username, code, scope, v.expiredAfter(scope), metadata)
+	}
+	return v.saveIfAbsent(username, code, scope, v.expiredAfter(scope), metadata)
 }
 
 func (v *VerificationCodeHandler) GetCode(username, scope string) (*VerificationCode, error) {
 	verify, err := v.repository.GetCode(username, scope)
-	fmt.Println(verify)
 	if err != nil {
 		return nil, err
 	}
 	return verify, nil
 }
 
-func (v *VerificationCodeHandler) CheckCode(username, code, scope string) (bool, error) {
+// CheckCode checks code against the active code of username for scope. With
+// Config.ValidCodes the codes RegenerateCode replaced are accepted too, and a valid code
+// consumes them all.
+func (v *VerificationCodeHandler) CheckCode(username, code, scope string, options ...CheckOption) (bool, error) {
+	if _, err := v.check(username, code, scope, options); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// VerifyCode checks a code like CheckCode and returns it with its metadata. When a
+// GrantIssuer is configured, the code is deleted after a successful check and a grant for
+// the next request is returned with it.
+func (v *VerificationCodeHandler) VerifyCode(username, code, scope string, options ...CheckOption) (*Verification, error) {
+	verify, err := v.check(username, code, scope, options)
+	if err != nil {
+		return nil, err
+	}
+	if v.config.GrantIssuer == nil {
+		return &Verification{Code: verify}, nil
+	}
+
+	v.repository.DeleteCode(username, scope)
+	grant, err := v.config.GrantIssuer.Issue(username, v.grantScope(scope), v.config.GrantTTL)
+	if err != nil {
+		return nil, err
+	}
+	grant.Scope = scope
+	return &Verification{Code: verify, Grant: grant}, nil
+}
+
+// RedeemGrant consumes a grant issued by VerifyCode for scope.
+func (v *VerificationCodeHandler) RedeemGrant(token, scope string) (*Grant, error) {
+	if v.config.GrantIssuer == nil {
+		return nil, ErrGrantsDisabled
+	}
+	return v.tenantGrant(v.config.GrantIssuer.Redeem(token, v.grantScope(scope)))
+}
+
+// PeekGrant checks a grant like RedeemGrant but leaves it usable.
+func (v *VerificationCodeHandler) PeekGrant(token, scope string) (*Grant, error) {
+	if v.config.GrantIssuer == nil {
+		return nil, ErrGrantsDisabled
+	}
+	return v.tenantGrant(v.config.GrantIssuer.Peek(token, v.grantScope(scope)))
+}
+
+// tenantGrant removes the tenant from the scope of a grant.
+func (v *VerificationCodeHandler) tenantGrant(grant *Grant, err error) (*Grant, error) {
+	if err != nil {
+		return nil, err
+	}
+	grant.Scope = strings.TrimPrefix(grant.Scope, v.grantScope(""))
+	return grant, nil
+}
+
+func (v *VerificationCodeHandler) check(username, code, scope string, options []CheckOption) (*VerificationCode, error) {
 	verify, err := v.repository.GetCode(username, scope)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	if normalizer, ok := v.generator.(CodeNormalizer); ok {
+		code = normalizer.Normalize(code)
+	}
+	if !verify.ExpiredAt.After(time.Now()) {
+		return nil, ErrCodeExpired
+	}
+	if verify.Code != code {
+		if verify, err = v.previousCode(username, code, scope); err != nil {
+			return nil, err
+		}
+	}
+	if policy := v.config.Scopes[scope].Binding; policy.enabled() {
+		var check checkOptions
+		for _, option := range options {
+			option(&check)
+		}
+		if !policy.matches(verify.Metadata, check.binding) {
+			return nil, ErrBindingMismatch
+		}
+	}
+	if err := v.consume(username, scope); err != nil {
+		return nil, err
+	}
+	if err := v.resetResend(username, scope); err != nil {
+		return nil, err
 	}
-	if verify.Code == code && verify.ExpiredAt.After(time.Now()) {
-		return true, nil
+	return verify, nil
+}
+
+// bind adds the binding of WithBinding to the metadata, the binding wins over metadata
+// with the same keys.
+func (v *VerificationCodeHandler) bind(options *generateOptions, scope string) error {
+	policy := v.config.Scopes[scope].Binding
+	if options.binding == nil {
+		if policy.enabled() {
+			return fmt.Errorf("%w: scope %s requires a binding", ErrInvalidBinding, scope)
+		}
+		return nil
+	}
+	binding, err := policy.metadata(*options.binding)
+	if err != nil {
+		return err
+	}
+	if len(binding) > 0 && options.metadata == nil {
+		options.metadata = make(map[string]string, len(binding))
+	}
+	for key, value := range binding {
+		options.metadata[key] = value
 	}
-	return false, errors.New("code expired")
+	return nil
+}
+
+// DeleteScope revokes every outstanding code of scope, e.g. after a security incident,
+// and returns how many codes were deleted.
+func (v *VerificationCodeHandler) DeleteScope(scope string) (int, error) {
+	if scope == "" {
+		return 0, errors.New("scope is required")
+	}
+	return v.repository.DeleteScope(scope)
+}
+
+// Repository returns the repository the handler stores codes in.
+func (v *VerificationCodeHandler) Repository() CodeRepositoryInterface {
+	return v.repository
 }
 
 func (v *VerificationCodeHandler) DeleteCode(username, scope string) bool {
 	return v.repository.DeleteCode(username, scope)
 }
 
-func (v *VerificationCodeHandler) RegenerateCode(username, scope string, resetExpireTime bool) (*VerificationCode, error) {
+// RegenerateCode replaces the active code of username for scope. Of the options only
+// WithRequestAttributes is used, the new code keeps the metadata of the active one.
+func (v *VerificationCodeHandler) RegenerateCode(username, scope string, resetExpireTime bool, options ...GenerateOption) (*VerificationCode, error) {
+	var generate generateOptions
+	for _, option := range options {
+		option(&generate)
+	}
 	verify, err := v.repository.GetCode(username, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	if resetExpireTime {
-		v.DeleteCode(username, scope)
-		saveCode, err := v.GenerateCode(username, scope)
-		if err != nil {
-			return nil, err
-		}
-		return saveCode, nil
+	releaseAbuse, err := v.checkAbuse(username, scope, generate.attributes)
+	if err != nil {
+		return nil, err
 	}
-
-	timeExpired := verify.ExpireAfter
-	if verify.ExpiredAt.After(time.Now()) {
-		timeExpired = int(verify.ExpiredAt.Sub(time.Now()).Seconds())
+	next, err := v.takeResend(username, scope)
+	if err != nil {
+		releaseAbuse()
+		return nil, err
 	}
-	code := v.generator.Generate()
-	v.repository.DeleteCode(username, scope)
-	saveCode, err := v.repository.SaveCode(username, code, scope, time.Duration(timeExpired)*time.Second)
+	saveCode, err := v.replaceCode(verify, resetExpireTime)
 	if err != nil {
+		v.releaseResend(username, scope, next)
+		releaseAbuse()
 		return nil, err
 	}
+	saveCode.NextResendAt = next
 	return saveCode, nil
 }
 
+// replaceCode saves a new code in place of verify, which is kept as a previous code.
+func (v *VerificationCodeHandler) replaceCode(verify *VerificationCode, resetExpireTime bool) (*VerificationCode, error) {
+	expiresTime := v.expiredAfter(verify.Scope)
+	if !resetExpireTime {
+		timeExpired := verify.ExpireAfter
+		if verify.ExpiredAt.After(time.Now()) {
+			timeExpired = int(verify.ExpiredAt.Sub(time.Now()).Seconds())
+		}
+		expiresTime = time.Duration(timeExpired) * time.Second
+	}
+	code, err := v.generate()
+	if err != nil {
+		return nil, err
+	}
+	if err := v.keepPrevious(verify); err != nil {
+		return nil, err
+	}
+	return v.save(verify.Username, code, verify.Scope, expiresTime, verify.Metadata)
+}
+
+func (v *VerificationCodeHandler) generate() (string, error) {
+	if generator, ok := v.generator.(FallibleGenerator); ok {
+		return generator.GenerateE()
+	}
+	code := v.generator.Generate()
+	if code == "" {
+		return "", errors.New("generator returned an empty code")
+	}
+	return code, nil
+}
+
+func (v *VerificationCodeHandler) expiredAfter(scope string) time.Duration {
+	if policy, ok := v.config.Scopes[scope]; ok && policy.ExpiredAfterSec != 0 {
+		return policy.ExpiredAfterSec
+	}
+	return v.config.ExpiredAfterSec
+}
+
 func checkConfig(config *Config) {
-	if config.ExpiredAfterSec.Seconds() < time.Minute.Seconds() {
-		config.ExpiredAfterSec = 2 * time.Minute
-	} else if config.ExpiredAfterSec.Seconds() > 10*time.Minute.Seconds() {
-		config.ExpiredAfterSec = 10 * time.Minute
+	config.ExpiredAfterSec = checkExpiredAfter(config.ExpiredAfterSec)
+	if config.GrantTTL <= 0 {
+		config.GrantTTL = 5 * time.Minute
+	}
+	for scope, policy := range config.Scopes {
+		if policy.ExpiredAfterSec != 0 {
+			policy.ExpiredAfterSec = checkExpiredAfter(policy.ExpiredAfterSec)
+			config.Scopes[scope] = policy
+		}
+	}
+}
+
+func checkExpiredAfter(expiredAfter time.Duration) time.Duration {
+	if expiredAfter.Seconds() < time.Minute.Seconds() {
+		return 2 * time.Minute
+	} else if expiredAfter.Seconds() > 10*time.Minute.Seconds() {
+		return 10 * time.Minute
+	}
+	return expiredAfter
+}
+
+func checkGeneratorStrength(generator CodeGenerator, config *Config) error {
+	if config.MaxGuessProbability <= 0 {
+		return nil
+	}
+	reporter, ok := generator.(EntropyReporter)
+	if !ok {
+		return errors.New("generator does not report its entropy")
+	}
+
+	attempts := config.MaxAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	// every valid code is another chance to guess right
+	attempts *= maxValidCodes(config)
+	entropy := reporter.Entropy()
+	probability := float64(attempts) / math.Exp2(entropy)
+	if probability > config.MaxGuessProbability {
+		return fmt.Errorf("generator is too weak: %.1f bits of entropy gives a %g chance to guess a code in %d attempts, max is %g",
+			entropy, probability, attempts, config.MaxGuessProbability)
 	}
+	return nil
 }
@@ -3,6 +3,7 @@ package go_verification
 import (
 	"errors"
 	"math/rand"
+	"strings"
 	"testing"
 	"time"
 )
@@ -21,6 +22,17 @@ func (m *MockCodeRepository) DeleteAllCodes(username string) bool {
 	return false
 }
 
+func (m *MockCodeRepository) DeleteScope(scope string) (int, error) {
+	deleted := 0
+	for key, code := range m.data {
+		if code.Scope == scope {
+			delete(m.data, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
 func (m *MockCodeRepository) SaveCode(username, code, scope string, expiresTime time.Duration) (*VerificationCode, error) {
 	key := username + scope
 	expiredAt := time.Now().Add(expiresTime)
@@ -40,7 +52,7 @@ func (m *MockCodeRepository) GetCode(username, scope string) (*VerificationCode,
 	key := username + scope
 	data, ok := m.data[key]
 	if !ok {
-		return nil, errors.New("does not exist")
+		return nil, ErrCodeNotFound
 	}
 	return data, nil
 }
@@ -173,3 +185,157 @@ func TestVerificationCodeHandler_RegenerateCode(t *testing.T) {
 		t.Errorf("Expected expiration time to be reset, got %d", regeneratedVerification.ExpireAfter)
 	}
 }
+
+func TestVerificationCodeHandler_CheckCodeNormalized(t *testing.T) {
+	generator, err := NewCharsetGenerator(CrockfordCharset, 0, "XXXX-XXXX")
+	if err != nil {
+		t.Fatalf("NewCharsetGenerator error: %v", err)
+	}
+	handler, err := NewVerificationCodeHandler(generator, NewMockCodeRepository(), &Config{ExpiredAfterSec: 5 * time.Minute})
+	if err != nil {
+		t.Fatalf("Failed to create VerificationCodeHandler: %v", err)
+	}
+
+	verification, err := handler.GenerateCode("testuser", "testscope")
+	if err != nil {
+		t.Fatalf("GenerateCode error: %v", err)
+	}
+
+	typed := strings.ToLower(strings.ReplaceAll(verification.Code, "-", " "))
+	match, err := handler.CheckCode("testuser", typed, "testscope")
+	if err != nil || !match {
+		t.Errorf("CheckCode failed: expected %q to match %q", typed, verification.Code)
+	}
+}
+
+func TestNewVerificationCodeHandler_MaxGuessProbability(t *testing.T) {
+	tests := []struct {
+		name      string
+		generator CodeGenerator
+		attempts  int
+		wantErr   bool
+	}{
+		{"Test strong generator", mustGenerator(NewNumberGenerator(8, false)), 5, false},
+		{"Test weak generator", mustGenerator(NewNumberGenerator(1, false)), 5, true},
+		{"Test weak regex", mustGenerator(NewRegexGenerator(`a`)), 0, true},
+		{"Test too many attempts", mustGenerator(NewNumberGenerator(6, false)), 1000, true},
+		{"Test generator without entropy", &MockCodeGenerator{length: 6}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewVerificationCodeHandler(tt.generator, NewMockCodeRepository(), &Config{
+				MaxAttempts:         tt.attempts,
+				MaxGuessProbability: 0.0001,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, but got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestVerificationCodeHandler_GenerateCodeError(t *testing.T) {
+	generator := mustGenerator(NewBlocklistGenerator(&MockCodeGenerator{defCode: "000000"}, DefaultBlocklist(), 2))
+	handler, err := NewVerificationCodeHandler(generator, NewMockCodeRepository(), &Config{ExpiredAfterSec: 5 * time.Minute})
+	if err != nil {
+		t.Fatalf("Failed to create VerificationCodeHandler: %v", err)
+	}
+
+	if _, err := handler.GenerateCode("testuser", "testscope"); !errors.Is(err, ErrBlocklistExhausted) {
+		t.Errorf("Expected ErrBlocklistExhausted, but got %v", err)
+	}
+	if _, err := handler.GetCode("testuser", "testscope"); err == nil {
+		t.Error("Expected no code to be saved")
+	}
+}
+
+func TestVerificationCodeHandler_CheckCodeErrors(t *testing.T) {
+	repository := NewMockCodeRepository()
+	handler, err := NewVerificationCodeHandler(&MockCodeGenerator{defCode: "123456"}, repository, &Config{ExpiredAfterSec: 5 * time.Minute})
+	if err != nil {
+		t.Fatalf("Failed to create VerificationCodeHandler: %v", err)
+	}
+
+	if _, err := handler.CheckCode("testuser", "123456", "testscope"); !errors.Is(err, ErrCodeNotFound) {
+		t.Errorf("Expected ErrCodeNotFound, got %v", err)
+	}
+
+	_, _ = handler.GenerateCode("testuser", "testscope")
+	if _, err := handler.CheckCode("testuser", "654321", "testscope"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Expected ErrInvalidCode, got %v", err)
+	}
+
+	_, _ = repository.SaveCode("testuser", "123456", "testscope", -time.Second)
+	if _, err := handler.CheckCode("testuser", "123456", "testscope"); !errors.Is(err, ErrCodeExpired) {
+		t.Errorf("Expected ErrCodeExpired, got %v", err)
+	}
+}
+
+func TestVerificationCodeHandler_VerifyCodeWithGrant(t *testing.T) {
+	repository := NewMemoryCodeRepository()
+	handler, err := NewVerificationCodeHandler(&MockCodeGenerator{defCode: "123456"}, repository, &Config{
+		ExpiredAfterSec: 5 * time.Minute,
+		GrantIssuer:     NewStoredGrantIssuer(repository),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create VerificationCodeHandler: %v", err)
+	}
+
+	_, _ = handler.GenerateCode("testuser", "forget-password")
+	verification, err := handler.VerifyCode("testuser", "123456", "forget-password")
+	if err != nil {
+		t.Fatalf("VerifyCode error: %v", err)
+	}
+	if verification.Code.Code != "123456" || verification.Grant == nil {
+		t.Fatalf("Expected the code and a grant, got %+v", verification)
+	}
+	if _, err := handler.GetCode("testuser", "forget-password"); !errors.Is(err, ErrCodeNotFound) {
+		t.Errorf("Expected the code to be consumed, got %v", err)
+	}
+
+	grant, err := handler.RedeemGrant(verification.Grant.Token, "forget-password")
+	if err != nil {
+		t.Fatalf("RedeemGrant error: %v", err)
+	}
+	if grant.Username != "testuser" {
+		t.Errorf("Expected testuser, got %s", grant.Username)
+	}
+	if _, err := handler.RedeemGrant(verification.Grant.Token, "forget-password"); !errors.Is(err, ErrInvalidGrant) {
+		t.Errorf("Expected ErrInvalidGrant on second redeem, got %v", err)
+	}
+}
+
+func TestVerificationCodeHandler_VerifyCodeWithoutGrant(t *testing.T) {
+	handler, _ := NewVerificationCodeHandler(&MockCodeGenerator{defCode: "123456"}, NewMockCodeRepository(), &Config{})
+	_, _ = handler.GenerateCode("testuser", "testscope")
+
+	verification, err := handler.VerifyCode("testuser", "123456", "testscope")
+	if err != nil || verification.Grant != nil {
+		t.Errorf("Expected a verification without grant, got %+v, %v", verification, err)
+	}
+	if _, err := handler.RedeemGrant("token", "testscope"); !errors.Is(err, ErrGrantsDisabled) {
+		t.Errorf("Expected ErrGrantsDisabled, got %v", err)
+	}
+}
+
+func TestVerificationCodeHandler_DeleteScope(t *testing.T) {
+	handler, _ := NewVerificationCodeHandler(&MockCodeGenerator{defCode: "123456"}, NewMockCodeRepository(), &Config{})
+	_, _ = handler.GenerateCode("user1", "forget-password")
+	_, _ = handler.GenerateCode("user2", "forget-password")
+	_, _ = handler.GenerateCode("user1", "login")
+
+	if _, err := handler.DeleteScope(""); err == nil {
+		t.Error("Expected an error for an empty scope")
+	}
+	deleted, err := handler.DeleteScope("forget-password")
+	if err != nil || deleted != 2 {
+		t.Errorf("Expected 2 deleted codes, but got %d, %v", deleted, err)
+	}
+	if _, err := handler.GetCode("user2", "forget-password"); !errors.Is(err, ErrCodeNotFound) {
+		t.Errorf("Expected the code to be deleted, got %v", err)
+	}
+	if _, err := handler.GetCode("user1", "login"); err != nil {
+		t.Errorf("Expected other scopes to stay, got %v", err)
+	}
+}